@@ -0,0 +1,294 @@
+// The bsongen command generates MarshalBSON methods for struct types. The
+// generated methods encode documents without reflection and produce the same
+// encoding as mongo.Encode.
+//
+// Add a go generate directive to the package containing the types:
+//
+//  //go:generate bsongen -type=User,Order
+//
+// The command writes the methods to user_bson.go in the package directory.
+// Use the -output flag to change the file name.
+//
+// Fields of type bool, string, time.Time and the integer and float types are
+// encoded directly. Fields of other types are encoded with
+// mongo.AppendElement. The command does not support anonymous fields, the
+// inline and string field tag options and the minsize option on fields of
+// types other than int64 and uint64.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
+	output    = flag.String("output", "", "output file name; default <type>_bson.go")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("bsongen: ")
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	types := strings.Split(*typeNames, ",")
+	src, err := generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, strings.ToLower(types[0])+"_bson.go")
+	}
+	if err := os.WriteFile(name, src, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the source for the MarshalBSON methods of the named types
+// in the package in directory dir.
+func generate(dir string, types []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("found %d packages in %s, want 1", len(pkgs), dir)
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	specs := make(map[string]*ast.TypeSpec)
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.TYPE {
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.TypeSpec)
+					specs[spec.Name.Name] = spec
+				}
+			}
+		}
+	}
+
+	g := &generator{}
+	g.printf("// Code generated by bsongen; DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", pkg.Name)
+	g.printf("import \"github.com/garyburd/go-mongo/mongo\"\n")
+	for _, name := range types {
+		spec := specs[name]
+		if spec == nil {
+			return nil, fmt.Errorf("type %s not found", name)
+		}
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return nil, fmt.Errorf("type %s is not a struct", name)
+		}
+		if err := g.writeMarshal(name, st); err != nil {
+			return nil, err
+		}
+	}
+	return format.Source(g.buf.Bytes())
+}
+
+type generator struct {
+	buf bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// field represents a struct field to encode.
+type field struct {
+	goName    string
+	name      string
+	typ       string
+	omitEmpty bool
+	minSize   bool
+}
+
+func (g *generator) writeMarshal(typeName string, st *ast.StructType) error {
+	var fields []field
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return fmt.Errorf("%s: anonymous fields not supported", typeName)
+		}
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			s, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return err
+			}
+			tag = reflect.StructTag(s)
+		}
+		for _, ident := range f.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			fd := field{goName: ident.Name, name: ident.Name, typ: typeString(f.Type)}
+			p := strings.Split(tag.Get("bson"), ",")
+			if p[0] == "-" {
+				continue
+			}
+			if p[0] != "" {
+				fd.name = p[0]
+			}
+			for _, s := range p[1:] {
+				switch s {
+				case "omitempty":
+					fd.omitEmpty = true
+				case "minsize":
+					fd.minSize = true
+				case "required", "truncate":
+					// Decode options.
+				default:
+					return fmt.Errorf("%s.%s: field tag option %s not supported", typeName, ident.Name, s)
+				}
+			}
+			if fd.minSize && fd.typ != "int64" && fd.typ != "uint64" {
+				// mongo.Encode rejects the option on other basic types. The
+				// underlying type of named types is not known.
+				return fmt.Errorf("%s.%s: field tag option minsize not supported for type %s", typeName, ident.Name, fd.typ)
+			}
+			fields = append(fields, fd)
+		}
+	}
+
+	needErr := false
+	for _, fd := range fields {
+		if appendFunc(fd) == "" {
+			needErr = true
+		}
+	}
+
+	g.printf("\n// MarshalBSON appends the BSON encoding of v to buf.\n")
+	g.printf("func (v *%s) MarshalBSON(buf []byte) ([]byte, error) {\n", typeName)
+	if needErr {
+		g.printf("var err error\n")
+	}
+	g.printf("buf, start := mongo.AppendDocumentStart(buf)\n")
+	for _, fd := range fields {
+		fn := appendFunc(fd)
+		if fn == "" {
+			if fd.minSize {
+				// A uint64 value that fits in an int32 is encoded as an int32.
+				g.printf("if v.%s < 1<<31 {\n", fd.goName)
+				if fd.omitEmpty {
+					g.printf("if %s {\n", zeroTest(fd))
+				}
+				g.printf("buf = mongo.AppendInt32(buf, %q, int32(v.%s))\n", fd.name, fd.goName)
+				if fd.omitEmpty {
+					g.printf("}\n")
+				}
+				g.printf("} else {\n")
+			}
+			g.printf("buf, err = mongo.AppendElement(buf, %q, v.%s, %v)\n", fd.name, fd.goName, fd.omitEmpty)
+			g.printf("if err != nil {\nreturn nil, err\n}\n")
+			if fd.minSize {
+				g.printf("}\n")
+			}
+			continue
+		}
+		if fd.omitEmpty {
+			g.printf("if %s {\n", zeroTest(fd))
+		}
+		arg := "v." + fd.goName
+		if t := argType[fn]; t != fd.typ {
+			arg = t + "(" + arg + ")"
+		}
+		g.printf("buf = mongo.%s(buf, %q, %s)\n", fn, fd.name, arg)
+		if fd.omitEmpty {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return mongo.AppendDocumentEnd(buf, start), nil\n")
+	g.printf("}\n")
+	return nil
+}
+
+var argType = map[string]string{
+	"AppendString": "string",
+	"AppendInt32":  "int32",
+	"AppendInt64":  "int64",
+	"AppendInt":    "int64",
+	"AppendFloat":  "float64",
+	"AppendBool":   "bool",
+	"AppendTime":   "time.Time",
+}
+
+// appendFunc returns the name of the mongo package function used to encode
+// the field or "" if the field is encoded with mongo.AppendElement. The
+// encodings match the encodings used by mongo.Encode.
+func appendFunc(fd field) string {
+	switch fd.typ {
+	case "string":
+		return "AppendString"
+	case "bool":
+		return "AppendBool"
+	case "float32", "float64":
+		return "AppendFloat"
+	case "int8", "int16", "int32", "uint8", "uint16":
+		return "AppendInt32"
+	case "int", "uint32":
+		return "AppendInt"
+	case "int64":
+		if fd.minSize {
+			return "AppendInt"
+		}
+		return "AppendInt64"
+	case "time.Time":
+		return "AppendTime"
+	}
+	return ""
+}
+
+// zeroTest returns an expression that is true if the field is not the zero
+// value.
+func zeroTest(fd field) string {
+	switch fd.typ {
+	case "string":
+		return "v." + fd.goName + ` != ""`
+	case "bool":
+		return "v." + fd.goName
+	case "time.Time":
+		return "!v." + fd.goName + ".IsZero()"
+	}
+	return "v." + fd.goName + " != 0"
+}
+
+// typeString returns the type expression for the simple types handled by
+// appendFunc.
+func typeString(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.SelectorExpr:
+		if pkg, ok := x.X.(*ast.Ident); ok {
+			return pkg.Name + "." + x.Sel.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("testdata", []string{"User"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("testdata/user_bson.go.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != string(expected) {
+		t.Errorf("generate() =\n%s\nwant\n%s", src, expected)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, types := range [][]string{{"Missing"}, {"Status"}, {"Embed"}, {"Inline"}, {"MinSize"}, {"MinSizeInt16"}} {
+		if _, err := generate("testdata", types); err == nil {
+			t.Errorf("generate(%v) did not return an error", types)
+		}
+	}
+}
+
+const encodeTypes = `package main
+
+type T struct {
+	A uint64 ` + "`bson:\"a,minsize\"`" + `
+	B uint64 ` + "`bson:\"b,minsize,omitempty\"`" + `
+	C int64  ` + "`bson:\"c,minsize\"`" + `
+}
+`
+
+const encodeMain = `package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/garyburd/go-mongo/mongo"
+)
+
+// plain has the fields of T and no MarshalBSON method.
+type plain T
+
+func main() {
+	status := 0
+	for _, v := range []T{
+		{},
+		{A: 1, B: 2, C: 3},
+		{A: math.MaxInt32, B: math.MaxInt32, C: math.MaxInt32},
+		{A: math.MaxInt32 + 1, B: math.MaxInt32 + 1, C: math.MaxInt32 + 1},
+		{A: math.MaxInt64, B: math.MaxInt64, C: math.MaxInt64},
+		{A: math.MaxInt64 + 1},
+		{B: math.MaxUint64},
+	} {
+		expected, expectedErr := mongo.Encode(nil, plain(v))
+		actual, actualErr := v.MarshalBSON(nil)
+		if (expectedErr != nil) != (actualErr != nil) || !bytes.Equal(actual, expected) {
+			fmt.Printf("%+v: MarshalBSON() = %v, %v; Encode() = %v, %v\n", v, actual, actualErr, expected, expectedErr)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+`
+
+// TestGenerateEncode checks that generated methods produce the same
+// encoding as mongo.Encode.
+func TestGenerateEncode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go run in short mode")
+	}
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "t.go"), []byte(encodeTypes), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(encodeMain), 0666); err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(dir, []string{"T"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "t_bson.go"), src, 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(gocmd, "run", "main.go", "t.go", "t_bson.go")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("%v\n%s\ngenerated source:\n%s", err, out, src)
+	}
+}
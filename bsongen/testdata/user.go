@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/garyburd/go-mongo/mongo"
+)
+
+type User struct {
+	Id      mongo.ObjectId `bson:"_id"`
+	Name    string         `bson:"name"`
+	Age     int            `bson:"age,omitempty"`
+	Big     int64          `bson:"big,minsize"`
+	Count   uint64         `bson:"count,minsize,omitempty"`
+	Score   float32
+	Active  bool      `bson:"active,omitempty"`
+	Created time.Time `bson:"created"`
+	Tags    []string  `bson:"tags,omitempty"`
+	skip    int
+	Ignored int `bson:"-"`
+}
+
+type Status int
+
+type Embed struct {
+	User
+}
+
+type Inline struct {
+	U User `bson:",inline"`
+}
+
+type MinSize struct {
+	S Status `bson:"s,minsize"`
+}
+
+type MinSizeInt16 struct {
+	N int16 `bson:"n,minsize"`
+}
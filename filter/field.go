@@ -0,0 +1,295 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/go-mongo/mongo"
+)
+
+// The functions in this file use the bson field tags of a struct type to
+// get document element names. Use these functions to ensure that filters and
+// updates reference fields that exist in the documents:
+//
+//  var u User
+//  c.Find(filter.Eq(filter.Field(&u, &u.Email), email))
+//
+//  c.Find(filter.Eq(filter.Name(u, "address.city"), city))
+
+var (
+	typeD        = reflect.TypeOf(mongo.D(nil))
+	typeRaw      = reflect.TypeOf(mongo.Raw(nil))
+	typeBSONData = reflect.TypeOf(mongo.BSONData{})
+)
+
+// Field returns the dotted path of the field pointed to by fieldPtr in the
+// struct pointed to by structPtr. Fields of nested structs are supported:
+//
+//  filter.Field(&u, &u.Address.City) // returns "address.city"
+//
+// Field panics if fieldPtr does not point to an encoded field in the struct.
+func Field(structPtr, fieldPtr interface{}) string {
+	path, err := FieldPath(structPtr, fieldPtr)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// FieldPath is like Field, but returns an error instead of panicking.
+func FieldPath(structPtr, fieldPtr interface{}) (string, error) {
+	sv := reflect.ValueOf(structPtr)
+	fv := reflect.ValueOf(fieldPtr)
+	if sv.Kind() != reflect.Ptr || sv.Elem().Kind() != reflect.Struct {
+		return "", errors.New("filter: structPtr must be a pointer to a struct")
+	}
+	if fv.Kind() != reflect.Ptr {
+		return "", errors.New("filter: fieldPtr must be a pointer to a field")
+	}
+	offset := fv.Pointer() - sv.Pointer()
+	if fv.Pointer() < sv.Pointer() || offset >= sv.Elem().Type().Size() {
+		return "", errors.New("filter: fieldPtr does not point into structPtr")
+	}
+	if path, ok := fieldAtOffset(sv.Elem().Type(), offset, fv.Type().Elem()); ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("filter: fieldPtr does not point to an encoded field in %s", sv.Elem().Type())
+}
+
+// fieldAtOffset returns the path of the field with type ft at offset in
+// struct type t.
+func fieldAtOffset(t reflect.Type, offset uintptr, ft reflect.Type) (string, bool) {
+	fields, _ := mongo.StructFieldInfo(t)
+	for _, f := range fields {
+		start, ok := fieldOffset(t, f.Index)
+		if !ok || offset < start || offset >= start+f.Type.Size() {
+			continue
+		}
+		if offset == start && f.Type == ft {
+			return f.Name, true
+		}
+		if f.Type.Kind() == reflect.Struct {
+			if path, ok := fieldAtOffset(f.Type, offset-start, ft); ok {
+				return f.Name + "." + path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// fieldOffset returns the offset of the field with the given index in
+// struct type t. The offset is not known if the field is reached through an
+// embedded pointer.
+func fieldOffset(t reflect.Type, index []int) (uintptr, bool) {
+	var offset uintptr
+	for _, i := range index {
+		if t.Kind() != reflect.Struct {
+			return 0, false
+		}
+		f := t.Field(i)
+		offset += f.Offset
+		t = f.Type
+	}
+	return offset, true
+}
+
+// Name returns path after checking that path is a valid dotted path for
+// documents encoded from v. The argument v is a struct, a pointer to a
+// struct or the reflect.Type of a struct. Path elements that follow an
+// array can be an array index or a positional operator. Name panics if the
+// path is not valid.
+func Name(v interface{}, path string) string {
+	t := structType(v)
+	if !validPath(t, path) {
+		panic(fmt.Errorf("filter: field %q not found in %s", path, t))
+	}
+	return path
+}
+
+// Check returns an error if a field name in filter or update document doc is
+// not a valid path for documents encoded from v. The argument v is specified
+// as described in the documentation for Name. The document is a mongo.D or
+// mongo.M.
+func Check(v interface{}, doc interface{}) error {
+	return checkDoc(structType(v), doc)
+}
+
+func structType(v interface{}) reflect.Type {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("filter: %v is not a struct type", t))
+	}
+	return t
+}
+
+// validPath returns true if path is a valid path in documents of type t.
+func validPath(t reflect.Type, path string) bool {
+	keys := strings.Split(path, ".")
+	for len(keys) > 0 {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t == typeD || t == typeRaw || t == typeBSONData {
+			return true
+		}
+		key := keys[0]
+		switch t.Kind() {
+		case reflect.Interface, reflect.Map:
+			// Any element name is allowed.
+			return true
+		case reflect.Struct:
+			fields, inlineMap := mongo.StructFieldInfo(t)
+			found := false
+			for _, f := range fields {
+				if f.Name == key {
+					t = f.Type
+					found = true
+					break
+				}
+			}
+			if !found {
+				return inlineMap
+			}
+		case reflect.Slice, reflect.Array:
+			t = t.Elem()
+			if !isArrayKey(key) {
+				// The key matches a field in the array elements.
+				continue
+			}
+		default:
+			return false
+		}
+		keys = keys[1:]
+	}
+	return true
+}
+
+// isArrayKey returns true if key is an array index or positional operator.
+func isArrayKey(key string) bool {
+	if key == "$" || strings.HasPrefix(key, "$[") {
+		return true
+	}
+	_, err := strconv.Atoi(key)
+	return err == nil
+}
+
+// pathOperators are the update operators with field names as keys.
+var pathOperators = map[string]bool{
+	"$set":         true,
+	"$setOnInsert": true,
+	"$unset":       true,
+	"$inc":         true,
+	"$mul":         true,
+	"$min":         true,
+	"$max":         true,
+	"$rename":      true,
+	"$currentDate": true,
+	"$push":        true,
+	"$addToSet":    true,
+	"$pull":        true,
+	"$pullAll":     true,
+	"$pop":         true,
+}
+
+// toD returns doc as a mongo.D.
+func toD(doc interface{}) (mongo.D, bool) {
+	switch doc := doc.(type) {
+	case mongo.D:
+		return doc, true
+	case mongo.M:
+		return doc.D(), true
+	case map[string]interface{}:
+		return mongo.M(doc).D(), true
+	}
+	return nil, false
+}
+
+func checkDoc(t reflect.Type, doc interface{}) error {
+	d, ok := toD(doc)
+	if !ok {
+		return fmt.Errorf("filter: document has type %T, want mongo.D or mongo.M", doc)
+	}
+	for _, item := range d {
+		switch {
+		case item.Key == "$and" || item.Key == "$or" || item.Key == "$nor":
+			var docs []interface{}
+			switch v := item.Value.(type) {
+			case mongo.A:
+				docs = v
+			case []interface{}:
+				docs = v
+			case []mongo.D:
+				for _, d := range v {
+					docs = append(docs, d)
+				}
+			default:
+				return fmt.Errorf("filter: value of %s is not an array", item.Key)
+			}
+			for _, doc := range docs {
+				if err := checkDoc(t, doc); err != nil {
+					return err
+				}
+			}
+		case pathOperators[item.Key]:
+			if err := checkKeys(t, item.Value); err != nil {
+				return err
+			}
+			if item.Key == "$rename" {
+				if err := checkRename(t, item.Value); err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(item.Key, "$"):
+			// Other operators such as $text and $where do not reference
+			// fields by name.
+		default:
+			if !validPath(t, item.Key) {
+				return fmt.Errorf("filter: field %q not found in %s", item.Key, t)
+			}
+		}
+	}
+	return nil
+}
+
+func checkKeys(t reflect.Type, doc interface{}) error {
+	d, _ := toD(doc)
+	for _, item := range d {
+		if !validPath(t, item.Key) {
+			return fmt.Errorf("filter: field %q not found in %s", item.Key, t)
+		}
+	}
+	return nil
+}
+
+func checkRename(t reflect.Type, doc interface{}) error {
+	d, _ := toD(doc)
+	for _, item := range d {
+		if name, ok := item.Value.(string); ok && !validPath(t, name) {
+			return fmt.Errorf("filter: field %q not found in %s", name, t)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/garyburd/go-mongo/mongo"
+)
+
+type fieldAddress struct {
+	Street string `bson:"street"`
+	City   string `bson:"city"`
+}
+
+type fieldBase struct {
+	Created time.Time `bson:"created"`
+}
+
+type fieldUser struct {
+	Id        mongo.ObjectId `bson:"_id"`
+	Email     string         `bson:"email"`
+	Age       int            `bson:"age,omitempty"`
+	Address   fieldAddress   `bson:"address"`
+	Addresses []fieldAddress `bson:"addresses"`
+	Tags      []string       `bson:"tags"`
+	Extra     mongo.M        `bson:"extra"`
+	Skip      string         `bson:"-"`
+	fieldBase
+}
+
+func TestField(t *testing.T) {
+	var u fieldUser
+	tests := []struct {
+		fieldPtr interface{}
+		path     string
+	}{
+		{&u.Id, "_id"},
+		{&u.Email, "email"},
+		{&u.Age, "age"},
+		{&u.Address, "address"},
+		{&u.Address.Street, "address.street"},
+		{&u.Address.City, "address.city"},
+		{&u.Tags, "tags"},
+		{&u.Created, "created"},
+	}
+	for _, tt := range tests {
+		path, err := FieldPath(&u, tt.fieldPtr)
+		if err != nil || path != tt.path {
+			t.Errorf("FieldPath(%T) = %q, %v, want %q", tt.fieldPtr, path, err, tt.path)
+		}
+	}
+
+	var other fieldUser
+	for _, fieldPtr := range []interface{}{&u.Skip, &other.Email, u} {
+		if path, err := FieldPath(&u, fieldPtr); err == nil {
+			t.Errorf("FieldPath(%T) = %q, want error", fieldPtr, path)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Field did not panic for unknown field")
+		}
+	}()
+	Field(&u, &u.Skip)
+}
+
+var validPathTests = []struct {
+	path  string
+	valid bool
+}{
+	{"_id", true},
+	{"email", true},
+	{"address.city", true},
+	{"addresses.city", true},
+	{"addresses.0.city", true},
+	{"addresses.$.city", true},
+	{"addresses.$[].city", true},
+	{"tags", true},
+	{"tags.0", true},
+	{"extra.anything.at.all", true},
+	{"created", true},
+	{"Email", false},
+	{"Skip", false},
+	{"address.zip", false},
+	{"addresses.zip", false},
+	{"email.x", false},
+	{"created.x", false},
+}
+
+func TestName(t *testing.T) {
+	for _, tt := range validPathTests {
+		valid := validPath(reflect.TypeOf(fieldUser{}), tt.path)
+		if valid != tt.valid {
+			t.Errorf("validPath(%q) = %v, want %v", tt.path, valid, tt.valid)
+		}
+	}
+	if name := Name(&fieldUser{}, "address.city"); name != "address.city" {
+		t.Errorf("Name() = %q, want address.city", name)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Name did not panic for unknown field")
+		}
+	}()
+	Name(fieldUser{}, "zip")
+}
+
+var checkTests = []struct {
+	doc   interface{}
+	valid bool
+}{
+	{Eq("email", "x"), true},
+	{Eq("mail", "x"), false},
+	{mongo.M{"address.city": "x"}, true},
+	{And(Gt("age", 1), Or(Eq("tags", "a"), Eq("address.zip", "x"))), false},
+	{And(Gt("age", 1), Or(Eq("tags", "a"), Eq("address.city", "x"))), true},
+	{Text("coffee"), true},
+	{mongo.D{{"$set", mongo.D{{"email", "x"}, {"address.street", "y"}}}, {"$inc", mongo.M{"age": 1}}}, true},
+	{mongo.D{{"$set", mongo.D{{"emial", "x"}}}}, false},
+	{mongo.D{{"$rename", mongo.D{{"email", "mail"}}}}, false},
+	{mongo.D{{"$push", mongo.D{{"addresses", mongo.D{{"city", "x"}}}}}}, true},
+}
+
+func TestCheck(t *testing.T) {
+	for _, tt := range checkTests {
+		err := Check(fieldUser{}, tt.doc)
+		if (err == nil) != tt.valid {
+			t.Errorf("Check(%v) = %v, want valid=%v", tt.doc, err, tt.valid)
+		}
+	}
+	if err := Check(reflect.TypeOf(fieldUser{}), 1); err == nil {
+		t.Error("Check with non-document did not return error")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package filter builds query filters, sort orders and projections.
+//
+// The functions in this package return mongo.D values that are passed to the
+// mongo package:
+//
+//  c.Find(filter.And(
+//      filter.Gt("weight", 500),
+//      filter.In("color", "red", "blue"))).
+//      Sort(filter.Sort(filter.Desc("weight"), filter.Asc("name"))).
+//      Fields(filter.Include("name", "weight"))
+//
+// More information: http://docs.mongodb.org/manual/reference/operator/query/
+package filter
+
+import (
+	"strings"
+
+	"github.com/garyburd/go-mongo/mongo"
+)
+
+// op returns the filter {name: {operator: value}}.
+func op(name, operator string, value interface{}) mongo.D {
+	return mongo.D{{name, mongo.D{{operator, value}}}}
+}
+
+// Eq matches documents where the value of the named field equals value.
+func Eq(name string, value interface{}) mongo.D {
+	return mongo.D{{name, value}}
+}
+
+// Ne matches documents where the value of the named field does not equal
+// value.
+func Ne(name string, value interface{}) mongo.D {
+	return op(name, "$ne", value)
+}
+
+// Gt matches documents where the value of the named field is greater than
+// value.
+func Gt(name string, value interface{}) mongo.D {
+	return op(name, "$gt", value)
+}
+
+// Gte matches documents where the value of the named field is greater than
+// or equal to value.
+func Gte(name string, value interface{}) mongo.D {
+	return op(name, "$gte", value)
+}
+
+// Lt matches documents where the value of the named field is less than
+// value.
+func Lt(name string, value interface{}) mongo.D {
+	return op(name, "$lt", value)
+}
+
+// Lte matches documents where the value of the named field is less than or
+// equal to value.
+func Lte(name string, value interface{}) mongo.D {
+	return op(name, "$lte", value)
+}
+
+// In matches documents where the value of the named field equals any of the
+// values.
+func In(name string, values ...interface{}) mongo.D {
+	return op(name, "$in", mongo.A(values))
+}
+
+// Nin matches documents where the value of the named field equals none of
+// the values.
+func Nin(name string, values ...interface{}) mongo.D {
+	return op(name, "$nin", mongo.A(values))
+}
+
+// All matches arrays in the named field that contain all of the values.
+func All(name string, values ...interface{}) mongo.D {
+	return op(name, "$all", mongo.A(values))
+}
+
+// Size matches arrays in the named field with n elements.
+func Size(name string, n int) mongo.D {
+	return op(name, "$size", n)
+}
+
+func logical(operator string, filters []mongo.D) mongo.D {
+	a := make(mongo.A, len(filters))
+	for i, f := range filters {
+		a[i] = f
+	}
+	return mongo.D{{operator, a}}
+}
+
+// And matches documents that match all of the filters.
+func And(filters ...mongo.D) mongo.D {
+	return logical("$and", filters)
+}
+
+// Or matches documents that match any of the filters.
+func Or(filters ...mongo.D) mongo.D {
+	return logical("$or", filters)
+}
+
+// Nor matches documents that match none of the filters.
+func Nor(filters ...mongo.D) mongo.D {
+	return logical("$nor", filters)
+}
+
+// Not matches documents where the named field does not match the operator
+// expression. The expression is a filter created by a function in this
+// package for the same field.
+//
+//  filter.Not(filter.Gt("price", 10))     // {price: {$not: {$gt: 10}}}
+//  filter.Not(filter.Eq("color", "red")) // {color: {$not: {$eq: "red"}}}
+//
+// Not panics if f does not have exactly one field or if the field is an
+// operator such as $and or $or. Use Nor to negate logical filters.
+func Not(f mongo.D) mongo.D {
+	if len(f) != 1 {
+		panic("filter: Not requires a filter with one field")
+	}
+	if strings.HasPrefix(f[0].Key, "$") {
+		panic("filter: Not requires a field filter, found " + f[0].Key)
+	}
+	v := f[0].Value
+	if !isOperatorExpression(v) {
+		v = mongo.D{{"$eq", v}}
+	}
+	return op(f[0].Key, "$not", v)
+}
+
+// isOperatorExpression returns true if v is a regular expression or a
+// document of query operators.
+func isOperatorExpression(v interface{}) bool {
+	switch v := v.(type) {
+	case mongo.Regexp:
+		return true
+	case mongo.D:
+		return len(v) > 0 && strings.HasPrefix(v[0].Key, "$")
+	}
+	return false
+}
+
+// ElemMatch matches documents where an element of the array in the named
+// field matches filter f.
+func ElemMatch(name string, f mongo.D) mongo.D {
+	return op(name, "$elemMatch", f)
+}
+
+// Regex matches documents where the value of the named field matches the
+// regular expression pattern. See mongo.Regexp for the valid options.
+func Regex(name, pattern, options string) mongo.D {
+	return mongo.D{{name, mongo.Regexp{Pattern: pattern, Options: options}}}
+}
+
+// Exists matches documents that contain the named field if exists is true
+// and documents that do not contain the named field otherwise.
+func Exists(name string, exists bool) mongo.D {
+	return op(name, "$exists", exists)
+}
+
+// Type matches documents where the value of the named field has the given
+// BSON type. The type is specified by number or by alias, for example 2 or
+// "string".
+func Type(name string, t interface{}) mongo.D {
+	return op(name, "$type", t)
+}
+
+// Near matches documents with the GeoJSON point in the named field ordered
+// by distance from the point at longitude lng and latitude lat. If
+// maxDistance is greater than zero, then only documents within maxDistance
+// meters of the point match.
+func Near(name string, lng, lat float64, maxDistance float64) mongo.D {
+	near := mongo.D{{"$geometry", mongo.D{{"type", "Point"}, {"coordinates", mongo.A{lng, lat}}}}}
+	if maxDistance > 0 {
+		near.Append("$maxDistance", maxDistance)
+	}
+	return op(name, "$near", near)
+}
+
+// Text matches documents using a text search of the fields in the
+// collection's text index.
+func Text(search string) mongo.D {
+	return mongo.D{{"$text", mongo.D{{"$search", search}}}}
+}
@@ -0,0 +1,80 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package filter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/garyburd/go-mongo/mongo"
+)
+
+type D = mongo.D
+type A = mongo.A
+
+var filterTests = []struct {
+	actual, expected mongo.D
+}{
+	{Eq("a", 1), D{{"a", 1}}},
+	{Ne("a", 1), D{{"a", D{{"$ne", 1}}}}},
+	{Gt("a", 1), D{{"a", D{{"$gt", 1}}}}},
+	{Gte("a", 1), D{{"a", D{{"$gte", 1}}}}},
+	{Lt("a", 1), D{{"a", D{{"$lt", 1}}}}},
+	{Lte("a", 1), D{{"a", D{{"$lte", 1}}}}},
+	{In("a", 1, 2), D{{"a", D{{"$in", A{1, 2}}}}}},
+	{Nin("a", "x"), D{{"a", D{{"$nin", A{"x"}}}}}},
+	{All("a", 1, 2), D{{"a", D{{"$all", A{1, 2}}}}}},
+	{Size("a", 3), D{{"a", D{{"$size", 3}}}}},
+	{And(Eq("a", 1), Gt("b", 2)), D{{"$and", A{D{{"a", 1}}, D{{"b", D{{"$gt", 2}}}}}}}},
+	{Or(Eq("a", 1)), D{{"$or", A{D{{"a", 1}}}}}},
+	{Nor(Eq("a", 1)), D{{"$nor", A{D{{"a", 1}}}}}},
+	{Not(Gt("a", 1)), D{{"a", D{{"$not", D{{"$gt", 1}}}}}}},
+	{Not(Eq("a", 5)), D{{"a", D{{"$not", D{{"$eq", 5}}}}}}},
+	{Not(Eq("a", D{{"b", 1}})), D{{"a", D{{"$not", D{{"$eq", D{{"b", 1}}}}}}}}},
+	{Not(Regex("a", "^x", "")), D{{"a", D{{"$not", mongo.Regexp{Pattern: "^x"}}}}}},
+	{ElemMatch("a", Gt("b", 1)), D{{"a", D{{"$elemMatch", D{{"b", D{{"$gt", 1}}}}}}}}},
+	{Regex("a", "^x", "i"), D{{"a", mongo.Regexp{Pattern: "^x", Options: "i"}}}},
+	{Exists("a", false), D{{"a", D{{"$exists", false}}}}},
+	{Type("a", "string"), D{{"a", D{{"$type", "string"}}}}},
+	{Near("a", 1.5, 2.5, 0), D{{"a", D{{"$near", D{{"$geometry", D{{"type", "Point"}, {"coordinates", A{1.5, 2.5}}}}}}}}}},
+	{Near("a", 1.5, 2.5, 10), D{{"a", D{{"$near", D{{"$geometry", D{{"type", "Point"}, {"coordinates", A{1.5, 2.5}}}}, {"$maxDistance", 10.0}}}}}}},
+	{Text("coffee"), D{{"$text", D{{"$search", "coffee"}}}}},
+	{Sort(Asc("a", "b"), Desc("c")), D{{"a", 1}, {"b", 1}, {"c", -1}}},
+	{Fields(Include("a"), Exclude("_id"), Slice("b", -5)), D{{"a", 1}, {"_id", 0}, {"b", D{{"$slice", -5}}}}},
+}
+
+func TestNotPanics(t *testing.T) {
+	for _, f := range []mongo.D{And(Eq("a", 1)), Or(Eq("a", 1)), Text("x"), {}, {{"a", 1}, {"b", 2}}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Not(%v) did not panic", f)
+				}
+			}()
+			Not(f)
+		}()
+	}
+}
+
+func TestFilter(t *testing.T) {
+	for i, tt := range filterTests {
+		if !reflect.DeepEqual(tt.actual, tt.expected) {
+			t.Errorf("%d: got %v, want %v", i, tt.actual, tt.expected)
+		}
+		if _, err := mongo.Encode(nil, tt.actual); err != nil {
+			t.Errorf("%d: encode returned error %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package filter
+
+import "github.com/garyburd/go-mongo/mongo"
+
+func keys(names []string, value interface{}) mongo.D {
+	d := make(mongo.D, len(names))
+	for i, name := range names {
+		d[i] = mongo.DocItem{Key: name, Value: value}
+	}
+	return d
+}
+
+func concat(parts []mongo.D) mongo.D {
+	var d mongo.D
+	for _, part := range parts {
+		d = append(d, part...)
+	}
+	return d
+}
+
+// Asc returns a sort order with the named fields in ascending order.
+func Asc(names ...string) mongo.D {
+	return keys(names, 1)
+}
+
+// Desc returns a sort order with the named fields in descending order.
+func Desc(names ...string) mongo.D {
+	return keys(names, -1)
+}
+
+// Sort returns the sort order created by concatenating the given sort
+// orders. Use the result with the mongo.Query Sort method.
+func Sort(orders ...mongo.D) mongo.D {
+	return concat(orders)
+}
+
+// Include returns a projection that includes the named fields.
+func Include(names ...string) mongo.D {
+	return keys(names, 1)
+}
+
+// Exclude returns a projection that excludes the named fields.
+func Exclude(names ...string) mongo.D {
+	return keys(names, 0)
+}
+
+// Slice returns a projection that limits the array in the named field to n
+// elements. If n is negative, then the last n elements are returned.
+func Slice(name string, n int) mongo.D {
+	return op(name, "$slice", n)
+}
+
+// Fields returns the projection created by concatenating the given
+// projections. Use the result with the mongo.Query Fields method.
+//
+//  filter.Fields(filter.Include("name"), filter.Slice("comments", 5))
+func Fields(projections ...mongo.D) mongo.D {
+	return concat(projections)
+}
@@ -15,21 +15,33 @@
 package mongo
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var emptyDoc = M{}
 
+// MaxNestingDepth is the maximum nesting depth of documents and arrays
+// supported by Encode and Decode. The value matches the limit enforced by
+// the server.
+const MaxNestingDepth = 200
+
+// ErrMaxDepth is returned by Encode and Decode when documents and arrays are
+// nested more than MaxNestingDepth levels.
+var ErrMaxDepth = errors.New("bson: maximum nesting depth exceeded")
+
 // Timestamp represents a BSON timesamp.
 type Timestamp int64
 
@@ -53,15 +65,26 @@ type Regexp struct {
 	Options string
 }
 
-// ObjectId represents a BSON object identifier.
+// ObjectId represents a BSON object identifier. A valid object id is 12
+// bytes long.
 type ObjectId string
 
-// String returns the hexadecimal encoding of id. Use the function
+// IsValid returns true if id is a valid object id.
+func (id ObjectId) IsValid() bool {
+	return len(id) == 12
+}
+
+// Hex returns the hexadecimal encoding of id. Use the function
 // NewObjectIdHex to convert the string back to an object id.
-func (id ObjectId) String() string {
+func (id ObjectId) Hex() string {
 	return hex.EncodeToString([]byte(string(id)))
 }
 
+// String returns the hexadecimal encoding of id.
+func (id ObjectId) String() string {
+	return id.Hex()
+}
+
 // MarshalJSON returns the JSON encoding of id.
 func (id ObjectId) MarshalJSON() ([]byte, error) {
 	return json.Marshal(id.String())
@@ -77,6 +100,32 @@ func (id *ObjectId) UnmarshalJSON(data []byte) error {
 	return err
 }
 
+// MarshalText returns the hexadecimal encoding of id. The empty object id is
+// encoded as empty text.
+func (id ObjectId) MarshalText() ([]byte, error) {
+	if len(id) == 0 {
+		return []byte{}, nil
+	}
+	if !id.IsValid() {
+		return nil, errors.New("mongo: bad object id len")
+	}
+	return []byte(id.Hex()), nil
+}
+
+// UnmarshalText decodes the hexadecimal encoding of an object id. Empty text
+// is decoded as the empty object id.
+func (id *ObjectId) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = ""
+		return nil
+	}
+	var err error
+	*id, err = NewObjectIdHex(string(text))
+	return err
+}
+
+// newObjectId returns an object id with time t and the remaining eight bytes
+// set to the big endian encoding of c.
 func newObjectId(t time.Time, c uint64) ObjectId {
 	u := t.Unix()
 	b := [12]byte{
@@ -95,17 +144,19 @@ func newObjectId(t time.Time, c uint64) ObjectId {
 	return ObjectId(b[:])
 }
 
-// NewObjectId returns a new object id. This function uses the following format
-// for object ids:
+// NewObjectId returns a new object id. This function uses the format
+// specified for all drivers:
+//
+//  [0:4]  Big endian time since epoch in seconds.
+//
+//  [4:9]  Random value generated once per process.
 //
-//  [0:4]  Big endian time since epoch in seconds. This is compatible
-//         with other drivers.
+//  [9:12] Big endian incrementing counter initialized with a random value.
 //
-//  [4:12] Incrementing counter initialized with cryptographic random number.
-//          This ensures that object ids are unique, but is simpler than
-//          the format used by other drivers.
+// More information: https://github.com/mongodb/specifications/blob/master/source/bson-objectid/objectid.md
 func NewObjectId() ObjectId {
-	return newObjectId(time.Now(), nextOidCounter())
+	c := atomic.AddUint32(&oidCounter, 1)
+	return newObjectId(time.Now(), oidProcessUnique<<24|uint64(c&0xffffff))
 }
 
 // NewObjectIdHex returns an object id initialized from the hexadecimal
@@ -133,29 +184,33 @@ func MinObjectIdForTime(t time.Time) ObjectId {
 	return newObjectId(t, 0)
 }
 
-// CreationTime extracts the time the object id was created in seconds since the epoch.
-func (id ObjectId) CreationTime() time.Time {
-	if len(id) != 12 {
+// Timestamp returns the time the object id was created with a resolution of
+// one second. Timestamp returns the zero time if id is not valid.
+func (id ObjectId) Timestamp() time.Time {
+	if !id.IsValid() {
 		return time.Time{}
 	}
-	return time.Unix(int64(id[0])<<24+int64(id[1])<<16+int64(id[2])<<8+int64(id[3]), 0)
+	return time.Unix(int64(binary.BigEndian.Uint32([]byte(id[:4]))), 0)
+}
+
+// CreationTime extracts the time the object id was created in seconds since
+// the epoch. CreationTime is equivalent to Timestamp.
+func (id ObjectId) CreationTime() time.Time {
+	return id.Timestamp()
 }
 
 var (
-	oidLock    sync.Mutex
-	oidCounter uint64
+	oidProcessUnique uint64
+	oidCounter       uint32
 )
 
-func nextOidCounter() uint64 {
-	oidLock.Lock()
-	defer oidLock.Unlock()
-	if oidCounter == 0 {
-		if err := binary.Read(rand.Reader, binary.BigEndian, &oidCounter); err != nil {
-			panic(err)
-		}
+func init() {
+	var b [9]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		panic(err)
 	}
-	oidCounter += 1
-	return oidCounter
+	oidProcessUnique = uint64(b[0])<<32 | uint64(binary.BigEndian.Uint32(b[1:5]))
+	oidCounter = binary.BigEndian.Uint32(b[5:9])
 }
 
 // BSONData represents a chunk of uninterpreted BSON data. Use this type to
@@ -168,7 +223,7 @@ type BSONData struct {
 // Deocde decodes bd to v. See the Decode function for more information about
 // BSON decoding.
 func (bd BSONData) Decode(v interface{}) error {
-	return decodeInternal(bd.Kind, bd.Data, v)
+	return decodeInternal(bd.Kind, bd.Data, v, false)
 }
 
 // Symbol represents a BSON symbol.
@@ -265,30 +320,141 @@ type fieldSpec struct {
 	name      string
 	index     []int
 	omitEmpty bool
+	minSize   bool
+	truncate  bool
+	asString  bool
+
+	// One plus the index of the field in structSpec.required or zero if the
+	// field is not required.
+	required int
+
+	// Position of the field in structSpec.l.
+	position int
+
+	// Element name used to match document elements to fields.
+	nameBytes []byte
+
+	// Encoder and decoder for the field type. The decoder is nil if the
+	// field must be decoded with decodeState.decodeValue.
+	encode encoderFunc
+	decode decoderFunc
 }
 
 type structSpec struct {
 	m      map[string]*fieldSpec
 	l      []*fieldSpec
 	fields D
+
+	// Index of inline map field or nil if the struct does not have an inline
+	// map.
+	inlineMap []int
+
+	// Required fields.
+	required []*fieldSpec
+
+	// True if pointers to the struct implement BSONMarshaler.
+	marshaler bool
 }
 
-func (ss *structSpec) fieldSpec(name []byte) *fieldSpec {
+// nextFieldSpec returns the field for the document element with the given
+// name. Documents are usually encoded in struct field order. The caller
+// passes the position following the last matched field to check for this
+// case before doing a map lookup.
+func (ss *structSpec) nextFieldSpec(name []byte, position int) *fieldSpec {
+	if position < len(ss.l) && bytes.Equal(ss.l[position].nameBytes, name) {
+		return ss.l[position]
+	}
 	return ss.m[string(name)]
 }
 
-func compileStructSpec(t reflect.Type, depth map[string]int, index []int, ss *structSpec) {
+// fieldIndex returns a copy of index with i appended.
+func fieldIndex(index []int, i int) []int {
+	result := make([]int, len(index)+1)
+	copy(result, index)
+	result[len(index)] = i
+	return result
+}
+
+// fieldByIndex returns the nested field of v with the given index. The
+// returned value is not valid if the field is in a nil embedded struct
+// pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// fieldByIndexType returns the type of the nested field of t with the given
+// index.
+func fieldByIndexType(t reflect.Type, index []int) reflect.Type {
+	for _, i := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		t = t.Field(i).Type
+	}
+	return t
+}
+
+// fieldByIndexAlloc is like fieldByIndex, but allocates nil embedded struct
+// pointers.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// isStructOrStructPtr returns true if t is a struct or a pointer to a struct.
+func isStructOrStructPtr(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct)
+}
+
+// compileInlineStruct adds the fields of the struct or struct pointer field
+// with the given index to ss. Types in the visiting map are not compiled to
+// prevent infinite recursion on recursive embedded pointer types.
+func compileInlineStruct(t reflect.Type, depth map[string]int, index []int, ss *structSpec, visiting map[reflect.Type]bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	compileStructSpec(t, depth, index, ss, visiting)
+	delete(visiting, t)
+}
+
+func compileStructSpec(t reflect.Type, depth map[string]int, index []int, ss *structSpec, visiting map[reflect.Type]bool) {
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
 		switch {
+		case f.Anonymous && isStructOrStructPtr(f.Type) && strings.Split(f.Tag.Get("bson"), ",")[0] == "":
+			// Fields of embedded structs are inlined. Fields of unexported
+			// embedded structs are included because the exported fields of
+			// the struct are accessible. This restores the encoding of
+			// unexported embedded structs from Go versions before 1.6,
+			// where reflect did not set PkgPath for embedded fields.
+			// Unexported embedded pointers are ignored because the decoder
+			// cannot allocate them.
+			if f.PkgPath == "" || f.Type.Kind() == reflect.Struct {
+				compileInlineStruct(f.Type, depth, fieldIndex(index, i), ss, visiting)
+			}
 		case f.PkgPath != "":
 			// Ignore unexported fields.
-		case f.Anonymous:
-			// TODO: Handle pointers. Requires change to decoder and
-			// protection against infinite recursion.
-			if f.Type.Kind() == reflect.Struct {
-				compileStructSpec(f.Type, depth, append(index, i), ss)
-			}
 		default:
 			fs := &fieldSpec{name: f.Name}
 			tag := f.Tag.Get("bson")
@@ -296,18 +462,57 @@ func compileStructSpec(t reflect.Type, depth map[string]int, index []int, ss *st
 				panic("use ,omitempty instead of /c in bson field tag")
 			}
 			p := strings.Split(tag, ",")
-			if len(p) > 0 && p[0] != "-" {
-				if len(p[0]) > 0 {
-					fs.name = p[0]
-				}
-				for _, s := range p[1:] {
-					switch s {
-					case "omitempty":
-						fs.omitEmpty = true
+			if p[0] == "-" {
+				continue
+			}
+			if len(p[0]) > 0 {
+				fs.name = p[0]
+			}
+			inline := false
+			for _, s := range p[1:] {
+				switch s {
+				case "omitempty":
+					fs.omitEmpty = true
+				case "minsize":
+					switch f.Type.Kind() {
+					case reflect.Int64, reflect.Uint64:
 					default:
-						panic(errors.New("bson: unknown field flag " + s + " for type " + t.Name()))
+						panic(errors.New("bson: minsize flag used with unsupported type for field " + f.Name + " of type " + t.Name()))
+					}
+					fs.minSize = true
+				case "required":
+					fs.required = 1
+				case "truncate":
+					fs.truncate = true
+				case "string":
+					switch f.Type.Kind() {
+					case reflect.Bool,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+						reflect.Float32, reflect.Float64:
+					default:
+						panic(errors.New("bson: string flag used with unsupported type for field " + f.Name + " of type " + t.Name()))
+					}
+					fs.asString = true
+				case "inline":
+					inline = true
+				default:
+					panic(errors.New("bson: unknown field flag " + s + " for type " + t.Name()))
+				}
+			}
+			if inline {
+				switch {
+				case isStructOrStructPtr(f.Type):
+					compileInlineStruct(f.Type, depth, fieldIndex(index, i), ss, visiting)
+				case f.Type.Kind() == reflect.Map && f.Type.Key().Kind() == reflect.String:
+					if ss.inlineMap != nil {
+						panic(errors.New("bson: multiple inline maps in type " + t.Name()))
 					}
+					ss.inlineMap = fieldIndex(index, i)
+				default:
+					panic(errors.New("bson: inline flag used with field " + f.Name + " of type " + t.Name() + "; want struct, struct pointer or map with string key"))
 				}
+				continue
 			}
 			d, found := depth[fs.name]
 			if !found {
@@ -326,9 +531,7 @@ func compileStructSpec(t reflect.Type, depth map[string]int, index []int, ss *st
 				}
 				ss.l = ss.l[:j]
 			case len(index) < d:
-				fs.index = make([]int, len(index)+1)
-				copy(fs.index, index)
-				fs.index[len(index)] = i
+				fs.index = fieldIndex(index, i)
 				depth[fs.name] = len(index)
 				ss.m[fs.name] = fs
 				ss.l = append(ss.l, fs)
@@ -360,19 +563,40 @@ func structSpecForType(t reflect.Type) *structSpec {
 	}
 
 	ss = &structSpec{m: make(map[string]*fieldSpec)}
-	compileStructSpec(t, make(map[string]int), nil, ss)
+	compileStructSpec(t, make(map[string]int), nil, ss, map[reflect.Type]bool{t: true})
 
-	hasId := false
-	for _, fs := range ss.l {
-		if fs.name == "_id" {
-			hasId = true
+	for i, fs := range ss.l {
+		if fs.required != 0 {
+			ss.required = append(ss.required, fs)
+			fs.required = len(ss.required)
+		}
+		ft := fieldByIndexType(t, fs.index)
+		fs.position = i
+		fs.nameBytes = []byte(fs.name)
+		if fs.asString {
+			fs.encode = encodeAsString
 		} else {
-			ss.fields.Append(fs.name, 1)
+			fs.encode = encoderForType(ft)
+			fs.decode = decoderForType(ft)
 		}
 	}
-	if !hasId {
-		// Explicitly exclude _id because it's included by default.
-		ss.fields.Append("_id", 0)
+	ss.marshaler = reflect.PtrTo(t).Implements(typeBSONMarshaler)
+
+	// An inline map receives all fields not matched by a struct field. Do
+	// not restrict the returned fields in that case.
+	if ss.inlineMap == nil {
+		hasId := false
+		for _, fs := range ss.l {
+			if fs.name == "_id" {
+				hasId = true
+			} else {
+				ss.fields.Append(fs.name, 1)
+			}
+		}
+		if !hasId {
+			// Explicitly exclude _id because it's included by default.
+			ss.fields.Append("_id", 0)
+		}
 	}
 
 	structSpecCache[t] = ss
@@ -385,6 +609,35 @@ func StructFields(t reflect.Type) interface{} {
 	return structSpecForType(t).fields
 }
 
+// FieldInfo describes a struct field that is encoded as a document element.
+type FieldInfo struct {
+	// Name of the document element.
+	Name string
+
+	// Index sequence for the reflect.Type FieldByIndex method.
+	Index []int
+
+	// Type of the field.
+	Type reflect.Type
+}
+
+// StructFieldInfo returns the fields of struct type t in the order that the
+// fields are encoded. Fields of inline structs are included. The inlineMap
+// result is true if the struct has an inline map that holds elements not
+// matched by a field.
+func StructFieldInfo(t reflect.Type) (fields []FieldInfo, inlineMap bool) {
+	ss := structSpecForType(t)
+	fields = make([]FieldInfo, len(ss.l))
+	for i, fs := range ss.l {
+		fields[i] = FieldInfo{
+			Name:  fs.name,
+			Index: append([]int(nil), fs.index...),
+			Type:  fieldByIndexType(t, fs.index),
+		}
+	}
+	return fields, ss.inlineMap != nil
+}
+
 type aborted struct{ err error }
 
 func abort(err error) { panic(aborted{err}) }
@@ -0,0 +1,123 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"math"
+	"reflect"
+	"time"
+)
+
+// The Append functions write BSON documents without reflection. The
+// functions are used by MarshalBSON methods generated by the bsongen command.
+//
+// A document is written as follows:
+//
+//  buf, start := mongo.AppendDocumentStart(buf)
+//  buf = mongo.AppendString(buf, "name", name)
+//  buf = mongo.AppendInt(buf, "age", int64(age))
+//  buf = mongo.AppendDocumentEnd(buf, start)
+
+// AppendDocumentStart appends the start of a document to buf. The returned
+// offset is passed to AppendDocumentEnd.
+func AppendDocumentStart(buf []byte) ([]byte, int) {
+	e := encodeState{buffer: buf}
+	start := e.beginDoc()
+	return e.buffer, start
+}
+
+// AppendDocumentEnd appends the end of the document started at offset start.
+func AppendDocumentEnd(buf []byte, start int) []byte {
+	e := encodeState{buffer: buf}
+	e.WriteByte(0)
+	e.endDoc(start)
+	return e.buffer
+}
+
+// AppendString appends a string element to buf.
+func AppendString(buf []byte, name string, s string) []byte {
+	e := encodeState{buffer: buf}
+	e.writeKindName(kindString, name)
+	e.WriteUint32(uint32(len(s) + 1))
+	e.WriteCString(s)
+	return e.buffer
+}
+
+// AppendInt32 appends a 32-bit integer element to buf.
+func AppendInt32(buf []byte, name string, i int32) []byte {
+	e := encodeState{buffer: buf}
+	e.writeKindName(kindInt32, name)
+	e.WriteUint32(uint32(i))
+	return e.buffer
+}
+
+// AppendInt64 appends a 64-bit integer element to buf.
+func AppendInt64(buf []byte, name string, i int64) []byte {
+	e := encodeState{buffer: buf}
+	e.writeKindName(kindInt64, name)
+	e.WriteUint64(uint64(i))
+	return e.buffer
+}
+
+// AppendInt appends a 32-bit integer element to buf if i fits in an int32.
+// Otherwise, a 64-bit integer element is appended.
+func AppendInt(buf []byte, name string, i int64) []byte {
+	if i >= math.MinInt32 && i <= math.MaxInt32 {
+		return AppendInt32(buf, name, int32(i))
+	}
+	return AppendInt64(buf, name, i)
+}
+
+// AppendFloat appends a double element to buf.
+func AppendFloat(buf []byte, name string, f float64) []byte {
+	e := encodeState{buffer: buf}
+	e.writeKindName(kindFloat, name)
+	e.WriteUint64(math.Float64bits(f))
+	return e.buffer
+}
+
+// AppendBool appends a boolean element to buf.
+func AppendBool(buf []byte, name string, b bool) []byte {
+	e := encodeState{buffer: buf}
+	e.writeKindName(kindBool, name)
+	if b {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+	return e.buffer
+}
+
+// AppendTime appends a UTC datetime element to buf.
+func AppendTime(buf []byte, name string, t time.Time) []byte {
+	e := encodeState{buffer: buf}
+	e.writeKindName(kindDateTime, name)
+	e.WriteUint64(uint64(msFromTime(t)))
+	return e.buffer
+}
+
+// AppendElement appends an element with value v to buf using the encodings
+// described in the documentation for the Encode function. If omitEmpty is
+// true, then the element is not written when v is the zero value.
+func AppendElement(buf []byte, name string, v interface{}, omitEmpty bool) (result []byte, err error) {
+	defer handleAbort(&err)
+	e := encodeState{buffer: buf}
+	fs := defaultFieldSpec
+	if omitEmpty {
+		fs = &fieldSpec{omitEmpty: true}
+	}
+	e.encodeValue(name, fs, reflect.ValueOf(v))
+	return e.buffer, nil
+}
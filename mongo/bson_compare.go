@@ -0,0 +1,277 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+)
+
+// Compare returns an integer comparing two values using the order used by
+// the server. The result is 0 if a == b, -1 if a < b and +1 if a > b.
+//
+// Values of different BSON types are ordered as follows:
+//
+//  MinValue
+//  Null
+//  Numbers (Integer32, Integer64, Double)
+//  String, Symbol
+//  Document
+//  Array
+//  Binary data
+//  ObjectId
+//  Boolean
+//  Datetime
+//  Timestamp
+//  Regular expression
+//  Javascript code
+//  Javascript code with scope
+//  MaxValue
+//
+// Numbers are compared by value across types. NaN is less than all other
+// numbers. Documents and arrays are compared element by element, first by
+// type, then by name and then by value. Strings are compared byte by byte.
+//
+// The arguments are encoded as described in the documentation for the Encode
+// function. A nil value, including nil pointers, maps and slices, compares
+// as Null. Compare panics with an error if a value cannot be encoded.
+//
+// More information: http://docs.mongodb.org/manual/reference/bson-types/#comparison-sort-order
+func Compare(a, b interface{}) int {
+	da, ka := compareState(a)
+	db, kb := compareState(b)
+	return compareValues(&da, ka, &db, kb)
+}
+
+// compareState encodes v and returns a decoder positioned at the encoded
+// value.
+func compareState(v interface{}) (decodeState, int) {
+	e := encodeState{}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if a, ok := r.(aborted); ok {
+					panic(a.err)
+				}
+				panic(r)
+			}
+		}()
+		e.encodeValue("", defaultFieldSpec, reflect.ValueOf(v))
+	}()
+	if len(e.buffer) == 0 {
+		return decodeState{}, kindNull
+	}
+	// Skip kind and empty name.
+	return decodeState{data: e.buffer, offset: 2}, int(e.buffer[0])
+}
+
+// canonicalKind returns the position of kind in the comparison order.
+func canonicalKind(kind int) int {
+	switch kind {
+	case kindMinValue:
+		return -1
+	case kindNull:
+		return 5
+	case kindFloat, kindInt32, kindInt64:
+		return 10
+	case kindString, kindSymbol:
+		return 15
+	case kindDocument:
+		return 20
+	case kindArray:
+		return 25
+	case kindBinary:
+		return 30
+	case kindObjectId:
+		return 35
+	case kindBool:
+		return 40
+	case kindDateTime:
+		return 45
+	case kindTimestamp:
+		return 47
+	case kindRegexp:
+		return 50
+	case kindCode:
+		return 60
+	case kindCodeWithScope:
+		return 65
+	case kindMaxValue:
+		return 100
+	}
+	abort(&DecodeTypeError{kind})
+	panic("unreachable")
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	}
+	// One or both values are NaN. NaN is less than all other numbers.
+	switch {
+	case !math.IsNaN(a):
+		return 1
+	case !math.IsNaN(b):
+		return -1
+	}
+	return 0
+}
+
+// compareIntFloat compares integer a with float b without loss of
+// precision.
+func compareIntFloat(a int64, b float64) int {
+	switch {
+	case math.IsNaN(b):
+		return 1
+	case b >= math.MaxInt64:
+		// The float64 value of MaxInt64 is 2^63.
+		return -1
+	case b < math.MinInt64:
+		return 1
+	}
+	i := int64(b)
+	if c := compareInts(a, i); c != 0 {
+		return c
+	}
+	// a equals the integer part of b. Compare with the fractional part.
+	return compareFloats(0, b-float64(i))
+}
+
+func (d *decodeState) scanNumber(kind int) (int64, float64, bool) {
+	switch kind {
+	case kindInt32:
+		return int64(d.scanInt32()), 0, true
+	case kindInt64:
+		return d.scanInt64(), 0, true
+	}
+	return 0, d.scanFloat(), false
+}
+
+func compareValues(da *decodeState, ka int, db *decodeState, kb int) int {
+	if c := compareInts(int64(canonicalKind(ka)), int64(canonicalKind(kb))); c != 0 {
+		return c
+	}
+	switch ka {
+	case kindFloat, kindInt32, kindInt64:
+		ia, fa, aIsInt := da.scanNumber(ka)
+		ib, fb, bIsInt := db.scanNumber(kb)
+		switch {
+		case aIsInt && bIsInt:
+			return compareInts(ia, ib)
+		case aIsInt:
+			return compareIntFloat(ia, fb)
+		case bIsInt:
+			return -compareIntFloat(ib, fa)
+		}
+		return compareFloats(fa, fb)
+	case kindString, kindSymbol, kindCode:
+		return compareStrings(da.scanString(), db.scanString())
+	case kindDocument, kindArray:
+		return compareDocs(da, db)
+	case kindBinary:
+		pa, sa := da.scanBinary()
+		pb, sb := db.scanBinary()
+		if c := compareInts(int64(len(pa)), int64(len(pb))); c != 0 {
+			return c
+		}
+		if c := compareInts(int64(sa), int64(sb)); c != 0 {
+			return c
+		}
+		return bytes.Compare(pa, pb)
+	case kindObjectId:
+		return bytes.Compare(da.scanSlice(12), db.scanSlice(12))
+	case kindBool:
+		return compareInts(int64(da.scanByte()), int64(db.scanByte()))
+	case kindDateTime:
+		return compareInts(da.scanInt64(), db.scanInt64())
+	case kindTimestamp:
+		a, b := uint64(da.scanInt64()), uint64(db.scanInt64())
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case kindRegexp:
+		if c := compareStrings(da.scanCString(), db.scanCString()); c != 0 {
+			return c
+		}
+		return compareStrings(da.scanCString(), db.scanCString())
+	case kindCodeWithScope:
+		da.scanInt32()
+		db.scanInt32()
+		if c := compareStrings(da.scanString(), db.scanString()); c != 0 {
+			return c
+		}
+		return compareDocs(da, db)
+	}
+	// MinValue, MaxValue, Null
+	return 0
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareDocs(da, db *decodeState) int {
+	offsetA := da.beginDoc()
+	offsetB := db.beginDoc()
+	for {
+		ka, na := da.scanKindName()
+		kb, nb := db.scanKindName()
+		switch {
+		case ka == 0 && kb == 0:
+			da.endDoc(offsetA)
+			db.endDoc(offsetB)
+			return 0
+		case ka == 0:
+			return -1
+		case kb == 0:
+			return 1
+		}
+		if c := compareInts(int64(canonicalKind(ka)), int64(canonicalKind(kb))); c != 0 {
+			return c
+		}
+		if c := bytes.Compare(na, nb); c != 0 {
+			return c
+		}
+		if c := compareValues(da, ka, db, kb); c != 0 {
+			return c
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+// compareOrder is a list of values in ascending order. Values in the same
+// inner slice are equal.
+var compareOrder = [][]interface{}{
+	{MinValue},
+	{nil, (*int)(nil), M(nil)},
+	{math.NaN()},
+	{math.Inf(-1)},
+	{int64(math.MinInt64)},
+	{-1.5},
+	{-1, int64(-1), -1.0},
+	{0, 0.0, int64(0)},
+	{0.5},
+	{1, int64(1), 1.0},
+	{int64(1<<53 + 1)},
+	{float64(1<<53 + 2), int64(1<<53 + 2)},
+	{int64(math.MaxInt64)},
+	{float64(math.MaxInt64)},
+	{math.Inf(1)},
+	{"", Symbol("")},
+	{"a", Symbol("a")},
+	{"ab"},
+	{"b"},
+	{M{}, D{}},
+	{M{"a": MinValue}},
+	{M{"a": 1}, D{{"a", 1.0}}},
+	{D{{"a", 1}, {"b", 1}}},
+	{D{{"a", 1}, {"c", 1}}},
+	{M{"b": 1}},
+	{M{"a": "x"}},
+	{A{}},
+	{A{1}, []int{1}},
+	{A{1, 2}},
+	{A{2}},
+	{[]byte("z")},
+	{[]byte("aa")},
+	{ObjectId("\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")},
+	{ObjectId("\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")},
+	{false},
+	{true},
+	{time.Unix(-1, 0)},
+	{time.Unix(1, 0)},
+	{Timestamp(1)},
+	{Timestamp(-1)},
+	{Regexp{"a", ""}},
+	{Regexp{"a", "i"}},
+	{Regexp{"b", ""}},
+	{Code("x")},
+	{CodeWithScope{"x", nil}},
+	{MaxValue},
+}
+
+func TestCompare(t *testing.T) {
+	for i, ei := range compareOrder {
+		for j, ej := range compareOrder {
+			expected := 0
+			switch {
+			case i < j:
+				expected = -1
+			case i > j:
+				expected = 1
+			}
+			for _, a := range ei {
+				for _, b := range ej {
+					if c := Compare(a, b); c != expected {
+						t.Errorf("Compare(%#v, %#v) = %d, want %d", a, b, c, expected)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCompareSort(t *testing.T) {
+	values := []interface{}{"b", 2, nil, MaxValue, 1.5, M{"a": 1}, true}
+	sort.Slice(values, func(i, j int) bool { return Compare(values[i], values[j]) < 0 })
+	expected := []interface{}{nil, 1.5, 2, "b", M{"a": 1}, true, MaxValue}
+	for i := range values {
+		if Compare(values[i], expected[i]) != 0 {
+			t.Fatalf("sorted = %v, want %v", values, expected)
+		}
+	}
+}
+
+func TestComparePanic(t *testing.T) {
+	defer func() {
+		if _, ok := recover().(*EncodeTypeError); !ok {
+			t.Error("Compare did not panic with EncodeTypeError")
+		}
+	}()
+	Compare(make(chan int), 1)
+}
@@ -15,23 +15,37 @@
 package mongo
 
 import (
+	"bytes"
+	"encoding"
 	"errors"
 	"math"
 	"reflect"
+	"strconv"
 	"time"
+	"unicode/utf8"
 )
 
 var ErrEOD = errors.New("bson: unexpected end of data when parsing BSON")
 
+var (
+	errBadDocLength = errors.New("bson: bad document length")
+	errBadString    = errors.New("bson: bad string")
+	errBadBinary    = errors.New("bson: bad binary length")
+)
+
 // DecodeConvertError is returned when decoder cannot convert BSON value to the
 // target type.
 type DecodeConvertError struct {
 	kind int
 	t    reflect.Type
+
+	// Path is the location of the value in the document, for example
+	// orders[3].items[0].price. Path is empty if the value is the document.
+	Path string
 }
 
 func (e *DecodeConvertError) Error() string {
-	return "bson: could not decode " + kindName(e.kind) + " to " + e.t.String()
+	return "bson: could not decode " + kindName(e.kind) + " to " + e.t.String() + atPath(e.Path)
 }
 
 // DecodeTypeError is returned when the decoder encounters an unknown type in
@@ -44,6 +58,54 @@ func (e *DecodeTypeError) Error() string {
 	return "bson: could not decode " + kindName(e.kind)
 }
 
+// DecodeRequiredError is returned when a struct field with the required
+// option is missing from the document or has a null value.
+type DecodeRequiredError struct {
+	t reflect.Type
+
+	// Path is the location of the missing field in the document.
+	Path string
+}
+
+func (e *DecodeRequiredError) Error() string {
+	return "bson: required field missing from " + e.t.String() + atPath(e.Path)
+}
+
+// DecodeUnknownFieldError is returned by DecodeStrict when a document element
+// does not match a struct field.
+type DecodeUnknownFieldError struct {
+	t reflect.Type
+
+	// Path is the location of the element in the document.
+	Path string
+}
+
+func (e *DecodeUnknownFieldError) Error() string {
+	return "bson: unknown field for " + e.t.String() + atPath(e.Path)
+}
+
+func atPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " at " + path
+}
+
+// joinPath returns the path formed by prefixing path with the name of a
+// document element or the index of an array element.
+func joinPath(name string, index int, path string) string {
+	if index >= 0 {
+		name = "[" + strconv.Itoa(index) + "]"
+	}
+	switch {
+	case path == "":
+		return name
+	case path[0] == '[':
+		return name + path
+	}
+	return name + "." + path
+}
+
 // Deocde decodes BSON data to value v.
 //
 // Decode traverses the value v recursively. Decode uses the inverse of the
@@ -57,7 +119,7 @@ func (e *DecodeTypeError) Error() string {
 //      Binary              -> []byte
 //      Boolean             -> bool
 //      Datetime            -> time.Time, int64
-//      Document            -> map[string]interface{}, struct types
+//      Document            -> map[string]interface{}, struct types, mongo.Raw
 //      Double              -> signed and unsigned integers, floats, bool
 //      MinValue, MaxValue  -> mongo.MinMax
 //      ObjectID            -> mongo.ObjectId
@@ -69,14 +131,37 @@ func (e *DecodeTypeError) Error() string {
 // to the target type, then the decoding completes the best it can and an error
 // is returned.
 //
+// A Double with a fractional part is not converted to an integer unless the
+// struct field has the truncate option.
+//
 // To decode a BSON value into a nil interface value, the first type listed in
 // the right hand column of the table above is used.
+//
+// Decode uses the options in the "bson" struct field tag as described in the
+// documentation for the Encode function. The following options only apply
+// to decoding:
+//
+//  required    If the element is missing from the document or the value is
+//              null, then Decode returns a *DecodeRequiredError.
+//  truncate    Allow lossy conversion from Double to integer types.
+//
+// Document elements that do not match a struct field are stored in the
+// struct's inline map if the struct has one. Fields with the string option
+// are parsed from a BSON string.
 func Decode(data []byte, v interface{}) (err error) {
-	return decodeInternal(kindDocument, data, v)
+	return decodeInternal(kindDocument, data, v, false)
+}
+
+// DecodeStrict is like Decode, but returns a *DecodeUnknownFieldError if a
+// document element does not match a struct field or inline map. Use
+// DecodeStrict to find differences between the documents in a collection and
+// the application's types.
+func DecodeStrict(data []byte, v interface{}) error {
+	return decodeInternal(kindDocument, data, v, true)
 }
 
 // decodeInternal decodes BSON data with given kind to v.
-func decodeInternal(kind int, data []byte, v interface{}) (err error) {
+func decodeInternal(kind int, data []byte, v interface{}, strict bool) (err error) {
 	defer handleAbort(&err)
 	value, ok := v.(reflect.Value)
 	if !ok {
@@ -96,7 +181,7 @@ func decodeInternal(kind int, data []byte, v interface{}) (err error) {
 		}
 	}
 
-	d := decodeState{data: data}
+	d := decodeState{data: data, strict: strict}
 	d.decodeValue(kind, value)
 	return d.savedError
 }
@@ -106,6 +191,15 @@ type decodeState struct {
 	data       []byte
 	offset     int // read offset in data
 	savedError error
+
+	// Allow lossy conversion from float to integer.
+	truncate bool
+
+	// Report document elements that do not match a struct field.
+	strict bool
+
+	// Nesting depth of documents and arrays.
+	depth int
 }
 
 // saveError saves the first err it is called with, for reporting at the end of
@@ -116,15 +210,30 @@ func (d *decodeState) saveError(err error) {
 	}
 }
 
+// addPath adds the name of a document element or the index of an array
+// element to the path in the saved error. Containers call addPath after
+// decoding an element if the element's value caused the first error.
+func (d *decodeState) addPath(name string, index int) {
+	switch e := d.savedError.(type) {
+	case *DecodeConvertError:
+		e.Path = joinPath(name, index, e.Path)
+	case *DecodeRequiredError:
+		e.Path = joinPath(name, index, e.Path)
+	case *DecodeUnknownFieldError:
+		e.Path = joinPath(name, index, e.Path)
+	}
+}
+
 // saveErrorAndSkip skips the value and saves a conversion error.
 func (d *decodeState) saveErrorAndSkip(kind int, t reflect.Type) {
 	d.skipValue(kind)
 	if d.savedError == nil {
-		d.savedError = &DecodeConvertError{kind, t}
+		d.savedError = &DecodeConvertError{kind: kind, t: t}
 	}
 }
 
 func (d *decodeState) beginDoc() int {
+	d.enterDoc()
 	offset := d.offset
 	offset += int(wire.Uint32(d.scanSlice(4)))
 	return offset
@@ -134,6 +243,16 @@ func (d *decodeState) endDoc(offset int) {
 	if d.offset != offset {
 		abort(errors.New("bson: doc length wrong"))
 	}
+	d.depth -= 1
+}
+
+// enterDoc increments the nesting depth. The limit on the depth prevents
+// stack overflow on hostile input.
+func (d *decodeState) enterDoc() {
+	d.depth += 1
+	if d.depth > MaxNestingDepth {
+		abort(ErrMaxDepth)
+	}
 }
 
 func (d *decodeState) scanByte() byte {
@@ -171,6 +290,60 @@ func (d *decodeState) scanKindName() (int, []byte) {
 	panic("unreachable")
 }
 
+func (d *decodeState) scanCString() string {
+	i := bytes.IndexByte(d.data[d.offset:], 0)
+	if i < 0 {
+		abort(ErrEOD)
+	}
+	p := d.scanSlice(i + 1)
+	return string(p[:i])
+}
+
+// beginValidDoc is like beginDoc, but checks that the document length is
+// valid.
+func (d *decodeState) beginValidDoc() int {
+	d.enterDoc()
+	n := int(d.scanInt32())
+	offset := d.offset - 4 + n
+	if n < 5 || offset > len(d.data) {
+		abort(errBadDocLength)
+	}
+	return offset
+}
+
+// scanValidString is like scanString, but checks the string length, null
+// terminator and UTF-8 encoding.
+func (d *decodeState) scanValidString() string {
+	n := int(d.scanInt32())
+	if n < 1 || n > len(d.data)-d.offset {
+		abort(errBadString)
+	}
+	p := d.scanSlice(n)
+	if p[n-1] != 0 || !utf8.Valid(p[:n-1]) {
+		abort(errBadString)
+	}
+	return string(p[:n-1])
+}
+
+// scanValidBinary is like scanBinary, but checks the binary length. The
+// length prefix of the old binary subtype 2 is removed from the returned
+// slice.
+func (d *decodeState) scanValidBinary() ([]byte, byte) {
+	n := int(d.scanInt32())
+	if n < 0 || n >= len(d.data)-d.offset {
+		abort(errBadBinary)
+	}
+	subtype := d.scanByte()
+	p := d.scanSlice(n)
+	if subtype == 2 {
+		if len(p) < 4 || int(int32(wire.Uint32(p))) != len(p)-4 {
+			abort(errBadBinary)
+		}
+		p = p[4:]
+	}
+	return p, subtype
+}
+
 func (d *decodeState) scanFloat() float64 {
 	return math.Float64frombits(wire.Uint64(d.scanSlice(8)))
 }
@@ -218,6 +391,19 @@ func (d *decodeState) decodeValue(kind int, v reflect.Value) {
 	decoder(d, kind, v)
 }
 
+// decoderForType returns the decoder for values of type t or nil if values
+// of type t must be decoded with decodeValue.
+func decoderForType(t reflect.Type) decoderFunc {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return nil
+	}
+	if decoder, ok := typeDecoder[t]; ok {
+		return decoder
+	}
+	return kindDecoder[t.Kind()]
+}
+
 // indirect walks down v allocating pointers as needed, until it gets to a
 // non-pointer.
 func (d *decodeState) indirect(v reflect.Value) reflect.Value {
@@ -251,7 +437,7 @@ func decodeFloat(d *decodeState, kind int, v reflect.Value) {
 		f = float64(d.scanInt32())
 	}
 	if v.OverflowFloat(f) {
-		d.saveError(&DecodeConvertError{kind, v.Type()})
+		d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
 		return
 	}
 	v.SetFloat(f)
@@ -268,10 +454,20 @@ func decodeInt(d *decodeState, kind int, v reflect.Value) {
 	case kindInt32:
 		n = int64(d.scanInt32())
 	case kindFloat:
-		n = int64(d.scanFloat())
+		f := d.scanFloat()
+		// The comparisons are false for NaN.
+		if !(f >= -(1<<63) && f < 1<<63) {
+			d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
+			return
+		}
+		n = int64(f)
+		if !d.truncate && float64(n) != f {
+			d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
+			return
+		}
 	}
 	if v.OverflowInt(n) {
-		d.saveError(&DecodeConvertError{kind, v.Type()})
+		d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
 		return
 	}
 	v.SetInt(n)
@@ -288,10 +484,20 @@ func decodeUint(d *decodeState, kind int, v reflect.Value) {
 	case kindInt32:
 		n = uint64(d.scanInt32())
 	case kindFloat:
-		n = uint64(d.scanFloat())
+		f := d.scanFloat()
+		// The comparisons are false for NaN.
+		if !(f > -1 && f < 1<<64) {
+			d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
+			return
+		}
+		n = uint64(f)
+		if !d.truncate && float64(n) != f {
+			d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
+			return
+		}
 	}
 	if v.OverflowUint(n) {
-		d.saveError(&DecodeConvertError{kind, v.Type()})
+		d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
 		return
 	}
 	v.SetUint(n)
@@ -349,6 +555,18 @@ func decodeBSONData(d *decodeState, kind int, v reflect.Value) {
 	v.Set(reflect.ValueOf(bd))
 }
 
+func decodeRaw(d *decodeState, kind int, v reflect.Value) {
+	if kind != kindDocument {
+		d.saveErrorAndSkip(kind, v.Type())
+		return
+	}
+	start := d.offset
+	d.skipValue(kind)
+	r := make(Raw, d.offset-start)
+	copy(r, d.data[start:d.offset])
+	v.Set(reflect.ValueOf(r))
+}
+
 func decodeByteSlice(d *decodeState, kind int, v reflect.Value) {
 	var p []byte
 	switch kind {
@@ -388,7 +606,7 @@ func decodeMinMax(d *decodeState, kind int, v reflect.Value) {
 	var n int64
 	switch kind {
 	default:
-		d.saveError(&DecodeConvertError{kind, v.Type()})
+		d.saveError(&DecodeConvertError{kind: kind, t: v.Type()})
 		return
 	case kindMaxValue:
 		n = 1
@@ -430,7 +648,7 @@ func decodeMapStringInterface(d *decodeState, kind int, v reflect.Value) {
 
 func decodeMap(d *decodeState, kind int, v reflect.Value) {
 	t := v.Type()
-	if t.Key().Kind() != reflect.String || kind != kindDocument {
+	if !isMapKeyType(t.Key()) || kind != kindDocument {
 		d.saveErrorAndSkip(kind, t)
 		return
 	}
@@ -447,13 +665,53 @@ func decodeMap(d *decodeState, kind int, v reflect.Value) {
 		if kind == kindNull {
 			continue
 		}
+		ok := d.savedError == nil
+		k, err := parseMapKey(string(name), t.Key())
+		if err != nil {
+			d.skipValue(kind)
+			d.saveError(&DecodeConvertError{kind: kindString, t: t.Key()})
+			if ok {
+				d.addPath(string(name), -1)
+			}
+			continue
+		}
 		subv.Set(reflect.Zero(t.Elem()))
 		d.decodeValue(kind, subv)
-		v.SetMapIndex(reflect.ValueOf(string(name)), subv)
+		if ok && d.savedError != nil {
+			d.addPath(string(name), -1)
+		}
+		v.SetMapIndex(k, subv)
 	}
 	d.endDoc(offset)
 }
 
+// parseMapKey converts document key s to a map key of type t.
+func parseMapKey(s string, t reflect.Type) (reflect.Value, error) {
+	k := reflect.New(t).Elem()
+	if t == typeObjectId {
+		id, err := NewObjectIdHex(s)
+		k.SetString(string(id))
+		return k, err
+	}
+	if tu, ok := k.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return k, tu.UnmarshalText([]byte(s))
+	}
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		k.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, t.Bits())
+		k.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, t.Bits())
+		k.SetUint(n)
+	}
+	return k, err
+}
+
 func decodeSlice(d *decodeState, kind int, v reflect.Value) {
 	t := v.Type()
 	if kind == kindBinary && t.Elem().Kind() == reflect.Uint8 {
@@ -483,7 +741,11 @@ func decodeSlice(d *decodeState, kind int, v reflect.Value) {
 		if i >= v.Len() {
 			v.SetLen(i + 1)
 		}
+		ok := d.savedError == nil
 		d.decodeValue(kind, v.Index(i))
+		if ok && d.savedError != nil {
+			d.addPath("", i)
+		}
 		i += 1
 	}
 	if v.IsNil() {
@@ -505,7 +767,11 @@ func decodeArray(d *decodeState, kind int, v reflect.Value) {
 			break
 		}
 		if i < v.Len() {
+			ok := d.savedError == nil
 			d.decodeValue(kind, v.Index(i))
+			if ok && d.savedError != nil {
+				d.addPath("", i)
+			}
 		} else {
 			d.skipValue(kind)
 		}
@@ -517,6 +783,12 @@ func decodeArray(d *decodeState, kind int, v reflect.Value) {
 func decodeStruct(d *decodeState, kind int, v reflect.Value) {
 	t := v.Type()
 	ss := structSpecForType(t)
+	var found []bool
+	if len(ss.required) > 0 {
+		found = make([]bool, len(ss.required))
+	}
+	truncate := d.truncate
+	position := 0
 	offset := d.beginDoc()
 	for {
 		kind, name := d.scanKindName()
@@ -526,13 +798,95 @@ func decodeStruct(d *decodeState, kind int, v reflect.Value) {
 		if kind == kindNull {
 			continue
 		}
-		if fs := ss.fieldSpec(name); fs != nil {
-			d.decodeValue(kind, v.FieldByIndex(fs.index))
-		} else {
+		ok := d.savedError == nil
+		fs := ss.nextFieldSpec(name, position)
+		switch {
+		case fs != nil:
+			if fs.required != 0 {
+				found[fs.required-1] = true
+			}
+			position = fs.position + 1
+			d.truncate = fs.truncate
+			fv := fieldByIndexAlloc(v, fs.index)
+			switch {
+			case fs.asString && kind == kindString:
+				d.decodeFromString(fv)
+			case fs.decode != nil:
+				fs.decode(d, kind, fv)
+			default:
+				d.decodeValue(kind, fv)
+			}
+		case ss.inlineMap != nil:
+			d.truncate = false
+			d.decodeInlineMapValue(kind, string(name), fieldByIndexAlloc(v, ss.inlineMap))
+		default:
 			d.skipValue(kind)
+			if d.strict {
+				d.saveError(&DecodeUnknownFieldError{t: t})
+			}
+		}
+		if ok && d.savedError != nil {
+			d.addPath(string(name), -1)
 		}
 	}
 	d.endDoc(offset)
+	d.truncate = truncate
+	for i, ok := range found {
+		if !ok {
+			d.saveError(&DecodeRequiredError{t: t, Path: ss.required[i].name})
+		}
+	}
+}
+
+// decodeInlineMapValue stores an element that does not match a struct field
+// in the struct's inline map.
+func (d *decodeState) decodeInlineMapValue(kind int, name string, m reflect.Value) {
+	t := m.Type()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(t))
+	}
+	var subv reflect.Value
+	if t.Elem().Kind() == reflect.Interface && t.Elem().NumMethod() == 0 {
+		subv = reflect.ValueOf(d.decodeValueInterface(kind))
+	} else {
+		subv = reflect.New(t.Elem()).Elem()
+		d.decodeValue(kind, subv)
+	}
+	m.SetMapIndex(reflect.ValueOf(name).Convert(t.Key()), subv)
+}
+
+// decodeFromString parses a BSON string to a bool or number. The function is
+// used for fields with the string option.
+func (d *decodeState) decodeFromString(v reflect.Value) {
+	s := d.scanString()
+	var err error
+	switch v.Kind() {
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(s, 10, v.Type().Bits()); err == nil {
+			v.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(s, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+	default:
+		err = errors.New("unsupported type")
+	}
+	if err != nil {
+		d.saveError(&DecodeConvertError{kind: kindString, t: v.Type()})
+	}
 }
 
 func decodeInterface(d *decodeState, kind int, v reflect.Value) {
@@ -602,10 +956,10 @@ func (d *decodeState) decodeValueInterface(kind int) interface{} {
 
 func (d *decodeState) skipValue(kind int) {
 	switch kind {
-	case kindString, kindSymbol:
+	case kindString, kindSymbol, kindCode:
 		n := int(d.scanInt32())
 		d.offset += n
-	case kindDocument, kindArray:
+	case kindDocument, kindArray, kindCodeWithScope:
 		n := int(d.scanInt32())
 		d.offset += n - 4
 	case kindBinary:
@@ -619,15 +973,23 @@ func (d *decodeState) skipValue(kind int) {
 		d.offset += 8
 	case kindInt32:
 		d.offset += 4
+	case kindRegexp:
+		d.scanCString()
+		d.scanCString()
 	case kindMinValue, kindMaxValue, kindNull:
 		d.offset += 0
 	default:
 		abort(&DecodeTypeError{kind})
 	}
+	if d.offset > len(d.data) {
+		abort(ErrEOD)
+	}
 }
 
 type decoderFunc func(e *decodeState, kind int, v reflect.Value)
 
+var typeInterface = reflect.TypeOf(new(interface{})).Elem()
+
 var kindDecoder map[reflect.Kind]decoderFunc
 var typeDecoder map[reflect.Type]decoderFunc
 
@@ -654,6 +1016,7 @@ func init() {
 	}
 	typeDecoder = map[reflect.Type]decoderFunc{
 		reflect.TypeOf(BSONData{}):                   decodeBSONData,
+		reflect.TypeOf(Raw(nil)):                     decodeRaw,
 		reflect.TypeOf(time.Time{}):                  decodeTime,
 		reflect.TypeOf(MinMax(0)):                    decodeMinMax,
 		reflect.TypeOf(ObjectId("")):                 decodeObjectId,
@@ -661,6 +1024,6 @@ func init() {
 		reflect.TypeOf(Timestamp(0)):                 decodeTimestamp,
 		reflect.TypeOf(make(map[string]interface{})): decodeMapStringInterface,
 		reflect.TypeOf(M{}):                          decodeMapStringInterface,
-		reflect.TypeOf(new(interface{})).Elem():      decodeInterface,
+		typeInterface:                                decodeInterface,
 	}
 }
@@ -0,0 +1,163 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+)
+
+// DiffOptions specifies options for the Diff function.
+type DiffOptions struct {
+	// Use $push for elements appended to an array. If false, then the
+	// modified array is set with $set.
+	Push bool
+}
+
+type differ struct {
+	options DiffOptions
+	set     D
+	unset   D
+	push    D
+}
+
+// Diff returns an update document that changes original to modified. The
+// arguments are any values accepted by Encode.
+//
+// Changed and added elements are set with the $set operator and removed
+// elements are removed with the $unset operator. Changes to nested documents
+// are set using the dotted path to the changed element. Arrays are set as a
+// whole unless the Push option is specified and elements were only appended
+// to the array. The top-level _id element is ignored.
+//
+// Diff returns an empty document if original and modified are equal. The
+// encoded values must have the same type to be equal. For example, the
+// integer 1 is not equal to the double 1.0.
+//
+// An example use of Diff is:
+//
+//  update, err := mongo.Diff(original, modified, nil)
+//  if err != nil {
+//      return err
+//  }
+//  if len(update) > 0 {
+//      err = c.Update(mongo.M{"_id": id}, update)
+//  }
+func Diff(original, modified interface{}, options *DiffOptions) (D, error) {
+	a, err := Encode(nil, original)
+	if err != nil {
+		return nil, err
+	}
+	b, err := Encode(nil, modified)
+	if err != nil {
+		return nil, err
+	}
+	var d differ
+	if options != nil {
+		d.options = *options
+	}
+	if err := d.diffDocs("", Raw(a), Raw(b)); err != nil {
+		return nil, err
+	}
+	var update D
+	if d.set != nil {
+		update.Append("$set", d.set)
+	}
+	if d.unset != nil {
+		update.Append("$unset", d.unset)
+	}
+	if d.push != nil {
+		update.Append("$push", d.push)
+	}
+	if update == nil {
+		update = D{}
+	}
+	return update, nil
+}
+
+func (d *differ) diffDocs(prefix string, a, b Raw) error {
+	ea, err := a.Elements()
+	if err != nil {
+		return err
+	}
+	eb, err := b.Elements()
+	if err != nil {
+		return err
+	}
+	m := make(map[string]BSONData, len(ea))
+	for _, e := range ea {
+		m[e.Name] = e.Value
+	}
+	for _, e := range eb {
+		if prefix == "" && e.Name == "_id" {
+			continue
+		}
+		path := prefix + e.Name
+		va, found := m[e.Name]
+		delete(m, e.Name)
+		switch {
+		case !found:
+			d.set.Append(path, e.Value)
+		case va.Kind == e.Value.Kind && bytes.Equal(va.Data, e.Value.Data):
+			// No change.
+		case va.Kind == kindDocument && e.Value.Kind == kindDocument:
+			if err := d.diffDocs(path+".", Raw(va.Data), Raw(e.Value.Data)); err != nil {
+				return err
+			}
+		case va.Kind == kindArray && e.Value.Kind == kindArray && d.options.Push:
+			if err := d.diffArrays(path, Raw(va.Data), Raw(e.Value.Data)); err != nil {
+				return err
+			}
+		default:
+			d.set.Append(path, e.Value)
+		}
+	}
+	// Elements are removed from m as they are matched. Iterate over ea
+	// instead of m to unset the remaining elements in document order.
+	for _, e := range ea {
+		if _, found := m[e.Name]; found && !(prefix == "" && e.Name == "_id") {
+			d.unset.Append(prefix+e.Name, "")
+		}
+	}
+	return nil
+}
+
+// diffArrays pushes the elements appended to array a to get array b. If b
+// is not a or more elements appended to a, then b is set.
+func (d *differ) diffArrays(path string, a, b Raw) error {
+	ea, err := a.Elements()
+	if err != nil {
+		return err
+	}
+	eb, err := b.Elements()
+	if err != nil {
+		return err
+	}
+	if len(eb) <= len(ea) {
+		d.set.Append(path, BSONData{kindArray, b})
+		return nil
+	}
+	for i, e := range ea {
+		if e.Value.Kind != eb[i].Value.Kind || !bytes.Equal(e.Value.Data, eb[i].Value.Data) {
+			d.set.Append(path, BSONData{kindArray, b})
+			return nil
+		}
+	}
+	each := make(A, 0, len(eb)-len(ea))
+	for _, e := range eb[len(ea):] {
+		each = append(each, e.Value)
+	}
+	d.push.Append(path, D{{"$each", each}})
+	return nil
+}
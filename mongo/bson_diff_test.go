@@ -0,0 +1,95 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"testing"
+)
+
+type diffAddress struct {
+	City string `bson:"city"`
+	Zip  string `bson:"zip,omitempty"`
+}
+
+type diffUser struct {
+	Id      int         `bson:"_id"`
+	Name    string      `bson:"name"`
+	Tags    []string    `bson:"tags"`
+	Address diffAddress `bson:"address"`
+}
+
+var diffTests = []struct {
+	original, modified interface{}
+	push               bool
+	expected           D
+}{
+	{M{"a": 1}, M{"a": 1}, false, D{}},
+	{M{"a": 1}, M{"a": 2}, false, D{{"$set", D{{"a", 2}}}}},
+	{M{"a": 1}, M{"a": 1.0}, false, D{{"$set", D{{"a", 1.0}}}}},
+	{M{"a": 1}, M{"a": 1, "b": "x"}, false, D{{"$set", D{{"b", "x"}}}}},
+	{D{{"a", 1}, {"b", 2}}, D{{"b", 2}}, false, D{{"$unset", D{{"a", ""}}}}},
+	{M{"a": M{"b": 1, "c": 2}}, M{"a": M{"b": 1, "c": 3}}, false, D{{"$set", D{{"a.c", 3}}}}},
+	{M{"a": M{"b": M{"c": 1}}}, M{"a": M{"b": M{}}}, false, D{{"$unset", D{{"a.b.c", ""}}}}},
+	{M{"a": M{"b": 1}}, M{"a": "x"}, false, D{{"$set", D{{"a", "x"}}}}},
+	{M{"a": A{1, 2}}, M{"a": A{1, 2, 3}}, false, D{{"$set", D{{"a", A{1, 2, 3}}}}}},
+	{M{"a": A{1, 2}}, M{"a": A{1, 2, 3, 4}}, true, D{{"$push", D{{"a", D{{"$each", A{3, 4}}}}}}}},
+	{M{"a": A{1, 2}}, M{"a": A{2, 3, 4}}, true, D{{"$set", D{{"a", A{2, 3, 4}}}}}},
+	{M{"a": A{1, 2}}, M{"a": A{1}}, true, D{{"$set", D{{"a", A{1}}}}}},
+	{M{"_id": 1}, M{"_id": 2}, false, D{}},
+	{M{"_id": 1, "a": 1}, M{"b": 1}, false, D{{"$set", D{{"b", 1}}}, {"$unset", D{{"a", ""}}}}},
+	{
+		diffUser{1, "bob", []string{"a"}, diffAddress{"Springfield", "12345"}},
+		diffUser{1, "alice", []string{"a", "b"}, diffAddress{"Springfield", ""}},
+		true,
+		D{
+			{"$set", D{{"name", "alice"}}},
+			{"$unset", D{{"address.zip", ""}}},
+			{"$push", D{{"tags", D{{"$each", A{"b"}}}}}},
+		},
+	},
+}
+
+func TestDiff(t *testing.T) {
+	for _, tt := range diffTests {
+		actual, err := Diff(tt.original, tt.modified, &DiffOptions{Push: tt.push})
+		if err != nil {
+			t.Errorf("Diff(%v, %v) returned error %v", tt.original, tt.modified, err)
+			continue
+		}
+		if actual == nil {
+			t.Errorf("Diff(%v, %v) returned nil document", tt.original, tt.modified)
+			continue
+		}
+		a, err := Encode(nil, actual)
+		if err != nil {
+			t.Errorf("Diff(%v, %v) returned document with encode error %v", tt.original, tt.modified, err)
+			continue
+		}
+		e, err := Encode(nil, tt.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, e) {
+			t.Errorf("Diff(%v, %v) = %v, want %v", tt.original, tt.modified, actual, tt.expected)
+		}
+	}
+}
+
+func TestDiffError(t *testing.T) {
+	if _, err := Diff(M{"a": 1}, M{"a": make(chan int)}, nil); err == nil {
+		t.Error("Diff did not return error for unsupported type")
+	}
+}
@@ -15,16 +15,20 @@
 package mongo
 
 import (
+	"encoding"
 	"errors"
 	"math"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var (
 	typeD        = reflect.TypeOf(D{})
 	typeBSONData = reflect.TypeOf(BSONData{})
+	typeRaw      = reflect.TypeOf(Raw(nil))
+	typeObjectId = reflect.TypeOf(ObjectId(""))
 	idKey        = reflect.ValueOf("_id")
 	itoas        = [...]string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
 )
@@ -38,8 +42,91 @@ func (e *EncodeTypeError) Error() string {
 	return "bson: unsupported type: " + e.Type.String()
 }
 
+var (
+	typeBSONMarshaler   = reflect.TypeOf((*BSONMarshaler)(nil)).Elem()
+	typeTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// BSONMarshaler is the interface implemented by struct types that can encode
+// themselves to a BSON document. The bsongen command generates
+// implementations of this interface that do not use reflection.
+type BSONMarshaler interface {
+	// MarshalBSON appends the BSON encoding of the receiver to buf and
+	// returns the new slice. MarshalBSON cannot call Encode with the
+	// receiver because Encode calls MarshalBSON.
+	MarshalBSON(buf []byte) ([]byte, error)
+}
+
+// EncodeCycleError is the error indicating that Encode encountered a value
+// that contains itself.
+type EncodeCycleError struct {
+	Type reflect.Type
+}
+
+func (e *EncodeCycleError) Error() string {
+	return "bson: encountered a cycle via " + e.Type.String()
+}
+
+// EncodeKeyError is the error indicating that Encode could not encode a
+// document key.
+type EncodeKeyError struct {
+	Key    string
+	reason string
+}
+
+func (e *EncodeKeyError) Error() string {
+	return "bson: key " + strconv.Quote(e.Key) + " " + e.reason
+}
+
+// Key checks performed by the encoder in addition to the check for NUL bytes.
+const (
+	// No additional checks.
+	keysAny = iota
+
+	// Keys in documents stored in the database cannot contain '.' or start
+	// with '$'.
+	keysStored
+
+	// An update document is either a document of update operators or a
+	// replacement document. The first key determines the type.
+	keysUpdate
+
+	// Top level keys of a document of update operators must start with '$'.
+	keysUpdateOperators
+)
+
+// Encode starts tracking pointers, maps and slices when the nesting depth plus
+// the number of pointer and interface indirections reaches
+// startDetectingCyclesAfter. The delay avoids the cost of tracking
+// for typical values.
+const startDetectingCyclesAfter = 32
+
+// ptrKey identifies a value referenced by a pointer, map or slice. The type
+// is included because a pointer to a struct and a pointer to the struct's
+// first field have the same address.
+type ptrKey struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
 type encodeState struct {
 	buffer
+
+	// Nesting depth of documents and arrays.
+	depth int
+
+	// Number of pointer and interface indirections on the path to the
+	// current value. Indirections are counted separately from depth so that
+	// they do not count toward MaxNestingDepth.
+	indirect int
+
+	// Pointers, maps and slices on the path to the current value.
+	ptrs map[ptrKey]bool
+
+	// Key check, one of the keys* constants.
+	keys int
 }
 
 // Encode appends the BSON encoding of doc to buf and returns the new slice.
@@ -56,13 +143,35 @@ type encodeState struct {
 //
 //  omitempty   If the field is the zero value, then the field is not
 //              written to the encoding.
+//  minsize     Encode int64 and uint64 values as Integer32 if the value
+//              fits in an int32. The option is not allowed on fields of
+//              other types.
+//  string      Encode bool and number values as BSON strings.
+//  inline      Encode the fields of a struct field in-line with the
+//              containing struct. The option can also be used with one
+//              map field per struct. The map entries are encoded in-line
+//              with the containing struct. A map key that is the same as
+//              a struct field name is an error.
+//  required    See the Decode function.
+//  truncate    See the Decode function.
 //
-// Anonymous struct fields are encoded in-line with the containing struct.
+// Struct types that implement the BSONMarshaler interface with a value or
+// pointer receiver are encoded by calling the MarshalBSON method.
+//
+// Anonymous struct and struct pointer fields are encoded in-line with the
+// containing struct. Nil struct pointers are skipped. The decoder allocates
+// an anonymous struct pointer when the document contains one of the struct's
+// fields. The exported fields of an anonymous struct with an unexported type
+// are also encoded in-line, as in the encoding/json package. Anonymous
+// pointers to unexported struct types are ignored.
 //
 // Array and slice values encode as BSON arrays.
 //
-// Map values encode as BSON documents. The map's key type must be string; the
-// object keys are used directly as map keys.
+// Map values encode as BSON documents. The map's key type must be a string,
+// integer or encoding.TextMarshaler type. Text marshalers are marshaled,
+// other string keys are used directly as document keys and other integer keys
+// are formatted in decimal. Keys of type mongo.ObjectId are encoded as hexadecimal
+// strings. The decoder parses document keys back to the map's key type.
 //
 // Pointer values encode as the value pointed to.
 //
@@ -86,15 +195,26 @@ type encodeState struct {
 //      mongo.D             -> Document. Use when element order is important.
 //      mongo.MinMax        -> Minimum / Maximum value
 //      mongo.ObjectId      -> ObjectId
+//      mongo.Raw           -> Document
 //      mongo.Regexp        -> Regular expression
 //      mongo.Symbol        -> Symbol
 //      mongo.Timestamp     -> Timestamp
 //
 // Other types including channels, complex and function values cannot be encoded.
 //
-// BSON cannot represent cyclic data structure and Encode does not handle them.
-// Passing cyclic structures to Encode will result in an infinite recursion.
+// Keys in documents cannot contain NUL bytes. Encode returns an
+// *EncodeKeyError if a key contains a NUL byte.
+//
+// BSON cannot represent cyclic data structures. Encode returns an
+// *EncodeCycleError if doc contains a pointer, map or slice that refers to
+// itself. Encode returns ErrMaxDepth if documents and arrays are nested more
+// than MaxNestingDepth levels.
 func Encode(buf []byte, doc interface{}) (result []byte, err error) {
+	return encodeInternal(buf, doc, keysAny)
+}
+
+// encodeInternal encodes doc with the given key check.
+func encodeInternal(buf []byte, doc interface{}, keys int) (result []byte, err error) {
 	defer handleAbort(&err)
 
 	v := reflect.ValueOf(doc)
@@ -102,7 +222,7 @@ func Encode(buf []byte, doc interface{}) (result []byte, err error) {
 		v = v.Elem()
 	}
 
-	e := encodeState{buffer: buf}
+	e := encodeState{buffer: buf, keys: keys}
 	switch v.Type() {
 	case typeD:
 		e.writeD(v.Interface().(D))
@@ -112,6 +232,8 @@ func Encode(buf []byte, doc interface{}) (result []byte, err error) {
 			return nil, &EncodeTypeError{v.Type()}
 		}
 		e.Write(bd.Data)
+	case typeRaw:
+		e.Write(v.Interface().(Raw))
 	default:
 		switch v.Kind() {
 		case reflect.Struct:
@@ -126,49 +248,155 @@ func Encode(buf []byte, doc interface{}) (result []byte, err error) {
 }
 
 func (e *encodeState) beginDoc() (offset int) {
+	e.depth += 1
+	if e.depth > MaxNestingDepth {
+		abort(ErrMaxDepth)
+	}
 	offset = len(e.buffer)
 	e.buffer.Next(4)
 	return
 }
 
 func (e *encodeState) endDoc(offset int) {
+	e.depth -= 1
 	n := len(e.buffer) - offset
 	wire.PutUint32(e.buffer[offset:offset+4], uint32(n))
 }
 
+// enterPointer records that the value referenced by pointer, map or slice v
+// is being encoded. The function aborts with an *EncodeCycleError if the
+// value is already being encoded. If enterPointer returns true, then the
+// caller must call exitPointer when done encoding v.
+func (e *encodeState) enterPointer(v reflect.Value) bool {
+	if e.depth+e.indirect < startDetectingCyclesAfter {
+		return false
+	}
+	k := pointerKey(v)
+	if e.ptrs[k] {
+		abort(&EncodeCycleError{v.Type()})
+	}
+	if e.ptrs == nil {
+		e.ptrs = make(map[ptrKey]bool)
+	}
+	e.ptrs[k] = true
+	return true
+}
+
+func (e *encodeState) exitPointer(v reflect.Value) {
+	delete(e.ptrs, pointerKey(v))
+}
+
+func pointerKey(v reflect.Value) ptrKey {
+	k := ptrKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	return k
+}
+
 func (e *encodeState) writeKindName(kind int, name string) {
+	if strings.IndexByte(name, 0) >= 0 {
+		abort(&EncodeKeyError{name, "contains NUL byte"})
+	}
+	if e.keys != keysAny {
+		e.checkKey(name)
+	}
 	e.WriteByte(byte(kind))
 	e.WriteCString(name)
 }
 
+// dbRefKeys are the keys starting with '$' that are allowed in stored
+// documents.
+var dbRefKeys = map[string]bool{"$ref": true, "$id": true, "$db": true}
+
+func (e *encodeState) checkKey(name string) {
+	switch e.keys {
+	case keysUpdate:
+		if strings.HasPrefix(name, "$") {
+			e.keys = keysUpdateOperators
+			return
+		}
+		e.keys = keysStored
+	case keysUpdateOperators:
+		if e.depth == 1 && !strings.HasPrefix(name, "$") {
+			abort(&EncodeKeyError{name, "is not an update operator"})
+		}
+		return
+	}
+	switch {
+	case strings.IndexByte(name, '.') >= 0:
+		abort(&EncodeKeyError{name, "contains '.'"})
+	case strings.HasPrefix(name, "$") && !dbRefKeys[name]:
+		abort(&EncodeKeyError{name, "starts with '$'"})
+	}
+}
+
 func (e *encodeState) writeStruct(v reflect.Value) {
-	offset := e.beginDoc()
 	ss := structSpecForType(v.Type())
+	if ss.marshaler {
+		e.writeMarshaler(v)
+		return
+	}
+	offset := e.beginDoc()
 	for _, fs := range ss.l {
-		e.encodeValue(fs.name, fs, v.FieldByIndex(fs.index))
+		if fv := fieldByIndex(v, fs.index); fv.IsValid() {
+			fs.encode(e, fs.name, fs, fv)
+		}
+	}
+	if ss.inlineMap != nil {
+		e.writeInlineMap(ss, fieldByIndex(v, ss.inlineMap))
 	}
 	e.WriteByte(0)
 	e.endDoc(offset)
 }
 
+func (e *encodeState) writeMarshaler(v reflect.Value) {
+	if !v.CanAddr() {
+		pv := reflect.New(v.Type())
+		pv.Elem().Set(v)
+		v = pv.Elem()
+	}
+	p, err := v.Addr().Interface().(BSONMarshaler).MarshalBSON(e.buffer)
+	if err != nil {
+		abort(err)
+	}
+	e.buffer = p
+}
+
+// writeInlineMap writes the entries of a struct's inline map. The map value
+// is not valid if the map is in a nil embedded struct pointer.
+func (e *encodeState) writeInlineMap(ss *structSpec, m reflect.Value) {
+	if !m.IsValid() {
+		return
+	}
+	for _, k := range m.MapKeys() {
+		sk := k.String()
+		if ss.m[sk] != nil {
+			abort(errors.New("bson: inline map key " + sk + " conflicts with struct field"))
+		}
+		e.encodeValue(sk, defaultFieldSpec, m.MapIndex(k))
+	}
+}
+
 func (e *encodeState) writeMap(v reflect.Value, topLevel bool) {
 	if v.IsNil() {
 		return
 	}
-	if v.Type().Key().Kind() != reflect.String {
+	keyType := v.Type().Key()
+	if !isMapKeyType(keyType) {
 		abort(&EncodeTypeError{v.Type()})
 	}
 	offset := e.beginDoc()
 	skipId := false
-	if topLevel {
-		idValue := v.MapIndex(idKey)
+	if topLevel && keyType.Kind() == reflect.String && keyType != typeObjectId {
+		idValue := v.MapIndex(idKey.Convert(keyType))
 		if idValue.IsValid() {
 			skipId = true
 			e.encodeValue("_id", defaultFieldSpec, idValue)
 		}
 	}
 	for _, k := range v.MapKeys() {
-		sk := k.String()
+		sk := mapKeyString(k)
 		if !skipId || sk != "_id" {
 			e.encodeValue(sk, defaultFieldSpec, v.MapIndex(k))
 		}
@@ -177,6 +405,44 @@ func (e *encodeState) writeMap(v reflect.Value, topLevel bool) {
 	e.endDoc(offset)
 }
 
+// isMapKeyType returns true if maps with key type t can be encoded and
+// decoded.
+func isMapKeyType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return t.Implements(typeTextMarshaler) && reflect.PtrTo(t).Implements(typeTextUnmarshaler)
+}
+
+// mapKeyString returns the document key for map key k. Object ids are
+// encoded as hexadecimal strings.
+func mapKeyString(k reflect.Value) string {
+	if k.Type() == typeObjectId {
+		id := ObjectId(k.String())
+		if len(id) != 12 {
+			abort(errors.New("bson: object id length != 12"))
+		}
+		return id.String()
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		p, err := tm.MarshalText()
+		if err != nil {
+			abort(err)
+		}
+		return string(p)
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	return k.String()
+}
+
 func (e *encodeState) writeD(v D) {
 	offset := e.beginDoc()
 	for _, kv := range v {
@@ -190,15 +456,56 @@ func (e *encodeState) encodeValue(name string, fs *fieldSpec, v reflect.Value) {
 	if !v.IsValid() {
 		return
 	}
-	t := v.Type()
-	encoder, found := typeEncoder[t]
-	if !found {
-		encoder, found = kindEncoder[t.Kind()]
-		if !found {
-			abort(&EncodeTypeError{t})
+	encoder := encoderForType(v.Type())
+	encoder(e, name, fs, v)
+}
+
+// encoderForType returns the encoder for values of type t.
+func encoderForType(t reflect.Type) encoderFunc {
+	if encoder, found := typeEncoder[t]; found {
+		return encoder
+	}
+	if encoder, found := kindEncoder[t.Kind()]; found {
+		return encoder
+	}
+	return encodeUnsupported
+}
+
+func encodeUnsupported(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	abort(&EncodeTypeError{v.Type()})
+}
+
+// encodeAsString encodes a bool or number as a BSON string. The function is
+// used for fields with the string option.
+func encodeAsString(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	var s string
+	switch v.Kind() {
+	case reflect.Bool:
+		if !v.Bool() && fs.omitEmpty {
+			return
+		}
+		s = strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() == 0 && fs.omitEmpty {
+			return
+		}
+		s = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() == 0 && fs.omitEmpty {
+			return
 		}
+		s = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		if v.Float() == 0 && fs.omitEmpty {
+			return
+		}
+		s = strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	default:
+		abort(&EncodeTypeError{v.Type()})
 	}
-	encoder(e, name, fs, v)
+	e.writeKindName(kindString, name)
+	e.WriteUint32(uint32(len(s) + 1))
+	e.WriteCString(s)
 }
 
 func encodeBool(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
@@ -268,6 +575,11 @@ func encodeInt64(e *encodeState, kind int, name string, fs *fieldSpec, v reflect
 	if i == 0 && fs.omitEmpty {
 		return
 	}
+	if kind == kindInt64 && fs.minSize && i >= math.MinInt32 && i <= math.MaxInt32 {
+		e.writeKindName(kindInt32, name)
+		e.WriteUint32(uint32(i))
+		return
+	}
 	e.writeKindName(kind, name)
 	e.WriteUint64(uint64(i))
 }
@@ -280,6 +592,11 @@ func encodeUint64(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	if int64(u) < 0 {
 		abort(errors.New("bson: uint64 value does not fit in int64"))
 	}
+	if fs.minSize && u <= math.MaxInt32 {
+		e.writeKindName(kindInt32, name)
+		e.WriteUint32(uint32(u))
+		return
+	}
 	e.writeKindName(kindInt64, name)
 	e.WriteUint64(u)
 }
@@ -334,6 +651,15 @@ func encodeBSONData(e *encodeState, name string, fs *fieldSpec, v reflect.Value)
 	e.Write(bd.Data)
 }
 
+func encodeRaw(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	r := v.Interface().(Raw)
+	if r == nil {
+		return
+	}
+	e.writeKindName(kindDocument, name)
+	e.Write(r)
+}
+
 func encodeCodeWithScope(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	c := v.Interface().(CodeWithScope)
 	if c.Code == "" && c.Scope == nil && fs.omitEmpty {
@@ -385,6 +711,9 @@ func encodeMap(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	if v.IsNil() {
 		return
 	}
+	if e.enterPointer(v) {
+		defer e.exitPointer(v)
+	}
 	e.writeKindName(kindDocument, name)
 	e.writeMap(v, false)
 }
@@ -394,6 +723,9 @@ func encodeD(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	if d == nil {
 		return
 	}
+	if e.enterPointer(v) {
+		defer e.exitPointer(v)
+	}
 	e.writeKindName(kindDocument, name)
 	e.writeD(d)
 }
@@ -421,6 +753,9 @@ func encodeSlice(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 }
 
 func encodeArray(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	if v.Kind() == reflect.Slice && e.enterPointer(v) {
+		defer e.exitPointer(v)
+	}
 	e.writeKindName(kindArray, name)
 	offset := e.beginDoc()
 	n := v.Len()
@@ -440,9 +775,13 @@ func encodeArray(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 func encodeInterfaceOrPtr(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	if v.IsNil() {
 		return
-	} else {
-		e.encodeValue(name, defaultFieldSpec, v.Elem())
 	}
+	if v.Kind() == reflect.Ptr && e.enterPointer(v) {
+		defer e.exitPointer(v)
+	}
+	e.indirect += 1
+	e.encodeValue(name, defaultFieldSpec, v.Elem())
+	e.indirect -= 1
 }
 
 type encoderFunc func(e *encodeState, name string, fs *fieldSpec, v reflect.Value)
@@ -480,6 +819,7 @@ func init() {
 	typeEncoder = map[reflect.Type]encoderFunc{
 		typeD:        encodeD,
 		typeBSONData: encodeBSONData,
+		typeRaw:      encodeRaw,
 		reflect.TypeOf(Code("")): func(e *encodeState, name string, fs *fieldSpec, value reflect.Value) {
 			encodeString(e, kindCode, name, fs, value)
 		},
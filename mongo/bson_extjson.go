@@ -0,0 +1,713 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxISODate is the last millisecond of the year 9999. Relaxed mode writes
+// dates after this time in canonical form.
+const maxISODate = 253402300799999
+
+// EncodeExtJSON appends the MongoDB Extended JSON v2 encoding of doc to buf and
+// returns the new slice.
+//
+// The argument doc is any value accepted by Encode. If doc is a []byte, then
+// doc is used directly as the BSON encoding of a document.
+//
+// If canonical is true, then the canonical format is used. The canonical
+// format preserves all type information. Otherwise, the relaxed format is
+// used. The relaxed format writes numbers as JSON numbers and dates between
+// the years 1970 and 9999 as ISO-8601 strings.
+//
+// More information: https://github.com/mongodb/specifications/blob/master/source/extended-json.rst
+func EncodeExtJSON(buf []byte, doc interface{}, canonical bool) (result []byte, err error) {
+	data, ok := doc.([]byte)
+	if !ok {
+		data, err = Encode(nil, doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defer handleAbort(&err)
+	s := extJSONState{
+		decodeState: decodeState{data: data},
+		buffer:      buf,
+		canonical:   canonical,
+	}
+	s.writeDoc(false)
+	if s.offset != len(data) {
+		return nil, errors.New("bson: unexpected data after document")
+	}
+	return s.buffer, nil
+}
+
+// DecodeExtJSON decodes MongoDB Extended JSON data to value v. Both the
+// canonical and relaxed formats are accepted. The JSON data is converted to
+// BSON and then decoded to v as described for the Decode function. Use a
+// *BSONData for v to get the BSON encoding of the document.
+func DecodeExtJSON(data []byte, v interface{}) error {
+	p, err := extJSONToBSON(nil, data)
+	if err != nil {
+		return err
+	}
+	return Decode(p, v)
+}
+
+// extJSONState represents the state while converting BSON to Extended JSON.
+type extJSONState struct {
+	decodeState
+	buffer
+	canonical bool
+}
+
+func (s *extJSONState) WriteString(str string) {
+	copy(s.Next(len(str)), str)
+}
+
+func (s *extJSONState) writeDoc(array bool) {
+	if array {
+		s.WriteByte('[')
+	} else {
+		s.WriteByte('{')
+	}
+	offset := s.beginValidDoc()
+	for i := 0; ; i++ {
+		kind, name := s.scanKindName()
+		if kind == 0 {
+			break
+		}
+		if i > 0 {
+			s.WriteByte(',')
+		}
+		if !array {
+			s.writeString(string(name))
+			s.WriteByte(':')
+		}
+		s.writeValue(kind)
+	}
+	s.endDoc(offset)
+	if array {
+		s.WriteByte(']')
+	} else {
+		s.WriteByte('}')
+	}
+}
+
+// writeWrapped writes the object {"key": "value"}.
+func (s *extJSONState) writeWrapped(key, value string) {
+	s.WriteString(`{"`)
+	s.WriteString(key)
+	s.WriteString(`":`)
+	s.writeString(value)
+	s.WriteByte('}')
+}
+
+func (s *extJSONState) writeValue(kind int) {
+	switch kind {
+	case kindFloat:
+		f := s.scanFloat()
+		if s.canonical || math.IsInf(f, 0) || math.IsNaN(f) {
+			s.writeWrapped("$numberDouble", formatExtJSONFloat(f))
+		} else {
+			s.WriteString(formatExtJSONFloat(f))
+		}
+	case kindString:
+		s.writeString(s.scanValidString())
+	case kindDocument:
+		s.writeDoc(false)
+	case kindArray:
+		s.writeDoc(true)
+	case kindBinary:
+		p, subtype := s.scanValidBinary()
+		s.WriteString(`{"$binary":{"base64":"`)
+		s.WriteString(base64.StdEncoding.EncodeToString(p))
+		s.WriteString(`","subType":"`)
+		s.WriteString(hex.EncodeToString([]byte{subtype}))
+		s.WriteString(`"}}`)
+	case kindObjectId:
+		s.writeWrapped("$oid", hex.EncodeToString(s.scanSlice(12)))
+	case kindBool:
+		switch s.scanByte() {
+		case 0:
+			s.WriteString("false")
+		case 1:
+			s.WriteString("true")
+		default:
+			abort(errors.New("bson: bad boolean value"))
+		}
+	case kindDateTime:
+		ms := s.scanInt64()
+		s.WriteString(`{"$date":`)
+		if !s.canonical && ms >= 0 && ms <= maxISODate {
+			s.writeString(timeFromMS(ms).Format("2006-01-02T15:04:05.999Z07:00"))
+		} else {
+			s.writeWrapped("$numberLong", strconv.FormatInt(ms, 10))
+		}
+		s.WriteByte('}')
+	case kindNull:
+		s.WriteString("null")
+	case kindRegexp:
+		pattern := s.scanCString()
+		options := []byte(s.scanCString())
+		sort.Sort(byteSlice(options))
+		s.WriteString(`{"$regularExpression":{"pattern":`)
+		s.writeString(pattern)
+		s.WriteString(`,"options":`)
+		s.writeString(string(options))
+		s.WriteString("}}")
+	case kindCode:
+		s.writeWrapped("$code", s.scanValidString())
+	case kindSymbol:
+		s.writeWrapped("$symbol", s.scanValidString())
+	case kindCodeWithScope:
+		start := s.offset
+		n := int(s.scanInt32())
+		if n < 14 || n > len(s.data)-start {
+			abort(errors.New("bson: bad code with scope length"))
+		}
+		s.WriteString(`{"$code":`)
+		s.writeString(s.scanValidString())
+		s.WriteString(`,"$scope":`)
+		s.writeDoc(false)
+		s.WriteByte('}')
+		if s.offset != start+n {
+			abort(errors.New("bson: bad code with scope length"))
+		}
+	case kindInt32:
+		n := strconv.FormatInt(int64(s.scanInt32()), 10)
+		if s.canonical {
+			s.writeWrapped("$numberInt", n)
+		} else {
+			s.WriteString(n)
+		}
+	case kindTimestamp:
+		u := uint64(s.scanInt64())
+		s.WriteString(`{"$timestamp":{"t":`)
+		s.WriteString(strconv.FormatUint(u>>32, 10))
+		s.WriteString(`,"i":`)
+		s.WriteString(strconv.FormatUint(u&0xffffffff, 10))
+		s.WriteString("}}")
+	case kindInt64:
+		n := strconv.FormatInt(s.scanInt64(), 10)
+		if s.canonical {
+			s.writeWrapped("$numberLong", n)
+		} else {
+			s.WriteString(n)
+		}
+	case kindMinValue:
+		s.WriteString(`{"$minKey":1}`)
+	case kindMaxValue:
+		s.WriteString(`{"$maxKey":1}`)
+	default:
+		abort(&DecodeTypeError{kind})
+	}
+}
+
+// writeString writes str as a JSON string. Unlike the encoding/json package,
+// HTML characters are not escaped.
+func (s *extJSONState) writeString(str string) {
+	const hexDigits = "0123456789abcdef"
+	s.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		b := str[i]
+		switch {
+		case b == '"' || b == '\\':
+			s.WriteByte('\\')
+			s.WriteByte(b)
+		case b >= 0x20:
+			s.WriteByte(b)
+		case b == '\b':
+			s.WriteString(`\b`)
+		case b == '\f':
+			s.WriteString(`\f`)
+		case b == '\n':
+			s.WriteString(`\n`)
+		case b == '\r':
+			s.WriteString(`\r`)
+		case b == '\t':
+			s.WriteString(`\t`)
+		default:
+			s.WriteString(`\u00`)
+			s.WriteByte(hexDigits[b>>4])
+			s.WriteByte(hexDigits[b&0xf])
+		}
+	}
+	s.WriteByte('"')
+}
+
+// formatExtJSONFloat formats f with the shortest representation that
+// round-trips. Integral values are written with a trailing ".0".
+func formatExtJSONFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	s := strconv.FormatFloat(f, 'G', -1, 64)
+	if !strings.ContainsAny(s, ".E") {
+		s += ".0"
+	}
+	return s
+}
+
+type byteSlice []byte
+
+func (p byteSlice) Len() int           { return len(p) }
+func (p byteSlice) Less(i, j int) bool { return p[i] < p[j] }
+func (p byteSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// extJSONMember is a key-value pair in a parsed JSON object.
+type extJSONMember struct {
+	key   string
+	value interface{}
+}
+
+// extJSONObject is a parsed JSON object. The order of the members is
+// preserved.
+type extJSONObject []extJSONMember
+
+func (obj extJSONObject) get(key string) (interface{}, bool) {
+	for _, m := range obj {
+		if m.key == key {
+			return m.value, true
+		}
+	}
+	return nil, false
+}
+
+// extJSONToBSON appends the BSON encoding of the Extended JSON document in data
+// to buf.
+func extJSONToBSON(buf []byte, data []byte) (result []byte, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := parseExtJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(extJSONObject)
+	if !ok {
+		return nil, errors.New("bson: Extended JSON value is not an object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("bson: unexpected data after Extended JSON object")
+	}
+
+	defer handleAbort(&err)
+	e := encodeState{buffer: buf}
+	e.writeExtJSONDoc(obj)
+	return e.buffer, nil
+}
+
+// parseExtJSONValue parses the next JSON value from dec. Objects are returned
+// as extJSONObject, arrays as []interface{} and numbers as json.Number.
+func parseExtJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := extJSONObject{}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := parseExtJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, extJSONMember{tok.(string), v})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case json.Delim('['):
+		a := []interface{}{}
+		for dec.More() {
+			v, err := parseExtJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+	return tok, nil
+}
+
+// extJSONKeywords is the list of keys that identify an Extended JSON type
+// wrapper object.
+var extJSONKeywords = []string{
+	"$oid", "$symbol", "$numberInt", "$numberLong", "$numberDouble",
+	"$numberDecimal", "$binary", "$uuid", "$code", "$timestamp",
+	"$regularExpression", "$regex", "$dbPointer", "$date", "$minKey",
+	"$maxKey", "$undefined",
+}
+
+func badExtJSON(keyword string) {
+	abort(errors.New("bson: invalid Extended JSON " + keyword + " value"))
+}
+
+func extJSONString(keyword string, v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		badExtJSON(keyword)
+	}
+	return s
+}
+
+func extJSONUint32(keyword string, v interface{}) uint32 {
+	n, ok := v.(json.Number)
+	if !ok {
+		badExtJSON(keyword)
+	}
+	u, err := strconv.ParseUint(string(n), 10, 32)
+	if err != nil {
+		badExtJSON(keyword)
+	}
+	return uint32(u)
+}
+
+func (e *encodeState) writeExtJSONDoc(obj extJSONObject) {
+	offset := e.beginDoc()
+	for _, m := range obj {
+		e.writeExtJSONValue(m.key, m.value)
+	}
+	e.WriteByte(0)
+	e.endDoc(offset)
+}
+
+func (e *encodeState) writeExtJSONValue(name string, v interface{}) {
+	if strings.IndexByte(name, 0) >= 0 {
+		abort(errors.New("bson: key contains null byte"))
+	}
+	switch v := v.(type) {
+	case nil:
+		e.writeKindName(kindNull, name)
+	case bool:
+		e.writeKindName(kindBool, name)
+		if v {
+			e.WriteByte(1)
+		} else {
+			e.WriteByte(0)
+		}
+	case string:
+		e.writeExtJSONString(kindString, name, v)
+	case json.Number:
+		s := string(v)
+		if !strings.ContainsAny(s, ".eE") {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				if n >= math.MinInt32 && n <= math.MaxInt32 {
+					e.writeKindName(kindInt32, name)
+					e.WriteUint32(uint32(n))
+				} else {
+					e.writeKindName(kindInt64, name)
+					e.WriteUint64(uint64(n))
+				}
+				return
+			}
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			abort(err)
+		}
+		e.writeKindName(kindFloat, name)
+		e.WriteUint64(math.Float64bits(f))
+	case []interface{}:
+		e.writeKindName(kindArray, name)
+		offset := e.beginDoc()
+		for i, elem := range v {
+			e.writeExtJSONValue(strconv.Itoa(i), elem)
+		}
+		e.WriteByte(0)
+		e.endDoc(offset)
+	case extJSONObject:
+		e.writeExtJSONObject(name, v)
+	}
+}
+
+func (e *encodeState) writeExtJSONString(kind int, name string, s string) {
+	e.writeKindName(kind, name)
+	e.WriteUint32(uint32(len(s) + 1))
+	e.WriteCString(s)
+}
+
+func (e *encodeState) writeExtJSONObject(name string, obj extJSONObject) {
+	keyword := ""
+	var v interface{}
+	for _, k := range extJSONKeywords {
+		var ok bool
+		if v, ok = obj.get(k); ok {
+			keyword = k
+			break
+		}
+	}
+
+	// The legacy $regex form conflicts with the $regex query operator.
+	// Treat the object as a regular document unless it has the form
+	// {"$regex": string, "$options": string}.
+	if keyword == "$regex" {
+		_, ok := v.(string)
+		if options, hasOptions := obj.get("$options"); hasOptions {
+			_, optionsOk := options.(string)
+			ok = ok && optionsOk && len(obj) == 2
+		} else {
+			ok = ok && len(obj) == 1
+		}
+		if !ok {
+			keyword = ""
+		}
+	}
+
+	if keyword == "" {
+		e.writeKindName(kindDocument, name)
+		e.writeExtJSONDoc(obj)
+		return
+	}
+
+	switch keyword {
+	case "$binary", "$code", "$regex":
+		// These types have legacy or optional members.
+	default:
+		if len(obj) != 1 {
+			badExtJSON(keyword)
+		}
+	}
+
+	switch keyword {
+	case "$oid":
+		p, err := hex.DecodeString(extJSONString(keyword, v))
+		if err != nil || len(p) != 12 {
+			badExtJSON(keyword)
+		}
+		e.writeKindName(kindObjectId, name)
+		e.Write(p)
+	case "$symbol":
+		e.writeExtJSONString(kindSymbol, name, extJSONString(keyword, v))
+	case "$numberInt":
+		n, err := strconv.ParseInt(extJSONString(keyword, v), 10, 32)
+		if err != nil {
+			badExtJSON(keyword)
+		}
+		e.writeKindName(kindInt32, name)
+		e.WriteUint32(uint32(n))
+	case "$numberLong":
+		n, err := strconv.ParseInt(extJSONString(keyword, v), 10, 64)
+		if err != nil {
+			badExtJSON(keyword)
+		}
+		e.writeKindName(kindInt64, name)
+		e.WriteUint64(uint64(n))
+	case "$numberDouble":
+		var f float64
+		switch s := extJSONString(keyword, v); s {
+		case "Infinity":
+			f = math.Inf(1)
+		case "-Infinity":
+			f = math.Inf(-1)
+		case "NaN":
+			f = math.NaN()
+		default:
+			var err error
+			f, err = strconv.ParseFloat(s, 64)
+			if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+				badExtJSON(keyword)
+			}
+		}
+		e.writeKindName(kindFloat, name)
+		e.WriteUint64(math.Float64bits(f))
+	case "$binary":
+		var data, subtype string
+		switch {
+		case len(obj) == 1:
+			b, ok := v.(extJSONObject)
+			if !ok || len(b) != 2 {
+				badExtJSON(keyword)
+			}
+			d, ok1 := b.get("base64")
+			t, ok2 := b.get("subType")
+			if !ok1 || !ok2 {
+				badExtJSON(keyword)
+			}
+			data = extJSONString(keyword, d)
+			subtype = extJSONString(keyword, t)
+		case len(obj) == 2:
+			t, ok := obj.get("$type")
+			if !ok {
+				badExtJSON(keyword)
+			}
+			data = extJSONString(keyword, v)
+			subtype = extJSONString(keyword, t)
+		default:
+			badExtJSON(keyword)
+		}
+		p, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			badExtJSON(keyword)
+		}
+		st, err := strconv.ParseUint(subtype, 16, 8)
+		if err != nil || len(subtype) > 2 {
+			badExtJSON(keyword)
+		}
+		e.writeExtJSONBinary(name, byte(st), p)
+	case "$uuid":
+		s := extJSONString(keyword, v)
+		if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			badExtJSON(keyword)
+		}
+		p, err := hex.DecodeString(s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:36])
+		if err != nil {
+			badExtJSON(keyword)
+		}
+		e.writeExtJSONBinary(name, 4, p)
+	case "$code":
+		code := extJSONString(keyword, v)
+		switch len(obj) {
+		case 1:
+			e.writeExtJSONString(kindCode, name, code)
+		case 2:
+			s, ok := obj.get("$scope")
+			if !ok {
+				badExtJSON(keyword)
+			}
+			scope, ok := s.(extJSONObject)
+			if !ok {
+				badExtJSON(keyword)
+			}
+			e.writeKindName(kindCodeWithScope, name)
+			offset := e.beginDoc()
+			e.WriteUint32(uint32(len(code) + 1))
+			e.WriteCString(code)
+			e.writeExtJSONDoc(scope)
+			e.endDoc(offset)
+		default:
+			badExtJSON(keyword)
+		}
+	case "$timestamp":
+		ts, ok := v.(extJSONObject)
+		if !ok || len(ts) != 2 {
+			badExtJSON(keyword)
+		}
+		t, ok1 := ts.get("t")
+		i, ok2 := ts.get("i")
+		if !ok1 || !ok2 {
+			badExtJSON(keyword)
+		}
+		e.writeKindName(kindTimestamp, name)
+		e.WriteUint32(extJSONUint32(keyword, i))
+		e.WriteUint32(extJSONUint32(keyword, t))
+	case "$regularExpression":
+		re, ok := v.(extJSONObject)
+		if !ok || len(re) != 2 {
+			badExtJSON(keyword)
+		}
+		pattern, ok1 := re.get("pattern")
+		options, ok2 := re.get("options")
+		if !ok1 || !ok2 {
+			badExtJSON(keyword)
+		}
+		e.writeExtJSONRegexp(name, extJSONString(keyword, pattern), extJSONString(keyword, options))
+	case "$regex":
+		options, _ := obj.get("$options")
+		s, _ := options.(string)
+		e.writeExtJSONRegexp(name, v.(string), s)
+	case "$date":
+		var ms int64
+		switch v := v.(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				t, err = time.Parse("2006-01-02T15:04:05.999999999Z0700", v)
+				if err != nil {
+					badExtJSON(keyword)
+				}
+			}
+			ms = msFromTime(t)
+		case extJSONObject:
+			n, ok := v.get("$numberLong")
+			if !ok || len(v) != 1 {
+				badExtJSON(keyword)
+			}
+			var err error
+			ms, err = strconv.ParseInt(extJSONString(keyword, n), 10, 64)
+			if err != nil {
+				badExtJSON(keyword)
+			}
+		default:
+			badExtJSON(keyword)
+		}
+		e.writeKindName(kindDateTime, name)
+		e.WriteUint64(uint64(ms))
+	case "$minKey", "$maxKey":
+		if n, ok := v.(json.Number); !ok || n != "1" {
+			badExtJSON(keyword)
+		}
+		if keyword == "$minKey" {
+			e.writeKindName(kindMinValue, name)
+		} else {
+			e.writeKindName(kindMaxValue, name)
+		}
+	default:
+		abort(errors.New("bson: unsupported Extended JSON type " + keyword))
+	}
+}
+
+func (e *encodeState) writeExtJSONBinary(name string, subtype byte, p []byte) {
+	e.writeKindName(kindBinary, name)
+	if subtype == 2 {
+		e.WriteUint32(uint32(len(p) + 4))
+		e.WriteByte(subtype)
+		e.WriteUint32(uint32(len(p)))
+	} else {
+		e.WriteUint32(uint32(len(p)))
+		e.WriteByte(subtype)
+	}
+	e.Write(p)
+}
+
+func (e *encodeState) writeExtJSONRegexp(name, pattern, options string) {
+	if strings.IndexByte(pattern, 0) >= 0 || strings.IndexByte(options, 0) >= 0 {
+		abort(errors.New("bson: regular expression contains null byte"))
+	}
+	p := []byte(options)
+	sort.Sort(byteSlice(p))
+	e.writeKindName(kindRegexp, name)
+	e.WriteCString(pattern)
+	e.WriteCString(string(p))
+}
@@ -0,0 +1,229 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type corpusTest struct {
+	Description string
+	Valid       []struct {
+		Description       string
+		CanonicalBSON     string `json:"canonical_bson"`
+		CanonicalExtJSON  string `json:"canonical_extjson"`
+		RelaxedExtJSON    string `json:"relaxed_extjson"`
+		DegenerateBSON    string `json:"degenerate_bson"`
+		DegenerateExtJSON string `json:"degenerate_extjson"`
+		Lossy             bool
+	}
+	DecodeErrors []struct {
+		Description string
+		BSON        string
+	}
+	ParseErrors []struct {
+		Description string
+		String      string
+	}
+}
+
+// jsonTokens returns the tokens in data. Comparing tokens ignores differences
+// in white space and string escapes.
+func jsonTokens(data []byte) ([]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tokens []interface{}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func sameJSON(t *testing.T, actual []byte, expected string) bool {
+	a, err := jsonTokens(actual)
+	if err != nil {
+		t.Errorf("invalid JSON %s: %v", actual, err)
+		return false
+	}
+	e, err := jsonTokens([]byte(expected))
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", expected, err)
+	}
+	return reflect.DeepEqual(a, e)
+}
+
+func TestExtJSONCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/bson-corpus/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no corpus files")
+	}
+	for _, file := range files {
+		p, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ct corpusTest
+		if err := json.Unmarshal(p, &ct); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		for _, v := range ct.Valid {
+			name := ct.Description + ": " + v.Description
+			cb, _ := hex.DecodeString(v.CanonicalBSON)
+
+			actual, err := EncodeExtJSON(nil, cb, true)
+			if err != nil {
+				t.Errorf("%s: EncodeExtJSON(cB, true) returned error %v", name, err)
+			} else if !sameJSON(t, actual, v.CanonicalExtJSON) {
+				t.Errorf("%s: EncodeExtJSON(cB, true) = %s, want %s", name, actual, v.CanonicalExtJSON)
+			}
+
+			var bd BSONData
+			if err := DecodeExtJSON([]byte(v.CanonicalExtJSON), &bd); err != nil {
+				t.Errorf("%s: DecodeExtJSON(cEJ) returned error %v", name, err)
+			} else if !v.Lossy && !bytes.Equal(bd.Data, cb) {
+				t.Errorf("%s: DecodeExtJSON(cEJ) = %X, want %X", name, bd.Data, cb)
+			}
+
+			if v.DegenerateBSON != "" {
+				db, _ := hex.DecodeString(v.DegenerateBSON)
+				actual, err := EncodeExtJSON(nil, db, true)
+				if err != nil {
+					t.Errorf("%s: EncodeExtJSON(dB, true) returned error %v", name, err)
+				} else if !sameJSON(t, actual, v.CanonicalExtJSON) {
+					t.Errorf("%s: EncodeExtJSON(dB, true) = %s, want %s", name, actual, v.CanonicalExtJSON)
+				}
+			}
+
+			if v.DegenerateExtJSON != "" {
+				var bd BSONData
+				if err := DecodeExtJSON([]byte(v.DegenerateExtJSON), &bd); err != nil {
+					t.Errorf("%s: DecodeExtJSON(dEJ) returned error %v", name, err)
+				} else if !v.Lossy && !bytes.Equal(bd.Data, cb) {
+					t.Errorf("%s: DecodeExtJSON(dEJ) = %X, want %X", name, bd.Data, cb)
+				}
+			}
+
+			if v.RelaxedExtJSON != "" {
+				actual, err := EncodeExtJSON(nil, cb, false)
+				if err != nil {
+					t.Errorf("%s: EncodeExtJSON(cB, false) returned error %v", name, err)
+				} else if !sameJSON(t, actual, v.RelaxedExtJSON) {
+					t.Errorf("%s: EncodeExtJSON(cB, false) = %s, want %s", name, actual, v.RelaxedExtJSON)
+				}
+
+				var bd BSONData
+				if err := DecodeExtJSON([]byte(v.RelaxedExtJSON), &bd); err != nil {
+					t.Errorf("%s: DecodeExtJSON(rEJ) returned error %v", name, err)
+				} else if actual, err := EncodeExtJSON(nil, bd, false); err != nil {
+					t.Errorf("%s: EncodeExtJSON(DecodeExtJSON(rEJ), false) returned error %v", name, err)
+				} else if !sameJSON(t, actual, v.RelaxedExtJSON) {
+					t.Errorf("%s: EncodeExtJSON(DecodeExtJSON(rEJ), false) = %s, want %s", name, actual, v.RelaxedExtJSON)
+				}
+			}
+		}
+		for _, v := range ct.DecodeErrors {
+			p, _ := hex.DecodeString(v.BSON)
+			if actual, err := EncodeExtJSON(nil, p, true); err == nil {
+				t.Errorf("%s: %s: EncodeExtJSON(%s) = %s, want error", ct.Description, v.Description, v.BSON, actual)
+			}
+		}
+		for _, v := range ct.ParseErrors {
+			var bd BSONData
+			if err := DecodeExtJSON([]byte(v.String), &bd); err == nil {
+				t.Errorf("%s: %s: DecodeExtJSON(%s) did not return an error", ct.Description, v.Description, v.String)
+			}
+		}
+	}
+}
+
+var extJSONTests = []struct {
+	doc       interface{}
+	canonical string
+	relaxed   string
+}{
+	{
+		D{{"a", 1}, {"b", int64(2)}, {"c", 1.5}},
+		`{"a":{"$numberInt":"1"},"b":{"$numberLong":"2"},"c":{"$numberDouble":"1.5"}}`,
+		`{"a":1,"b":2,"c":1.5}`,
+	},
+	{
+		M{"t": time.Unix(1356351330, 501e6)},
+		`{"t":{"$date":{"$numberLong":"1356351330501"}}}`,
+		`{"t":{"$date":"2012-12-24T12:15:30.501Z"}}`,
+	},
+	{
+		&struct {
+			Id   ObjectId `bson:"_id"`
+			Tags []string
+			Re   Regexp
+		}{
+			Id:   ObjectId("\x4C\x9B\x8F\xB4\xA3\x82\xAA\xFE\x17\xC8\x6E\x63"),
+			Tags: []string{"x", "<y>"},
+			Re:   Regexp{"^a", "i"},
+		},
+		`{"_id":{"$oid":"4c9b8fb4a382aafe17c86e63"},"Tags":["x","<y>"],"Re":{"$regularExpression":{"pattern":"^a","options":"i"}}}`,
+		`{"_id":{"$oid":"4c9b8fb4a382aafe17c86e63"},"Tags":["x","<y>"],"Re":{"$regularExpression":{"pattern":"^a","options":"i"}}}`,
+	},
+}
+
+func TestEncodeExtJSON(t *testing.T) {
+	for _, tt := range extJSONTests {
+		for _, canonical := range []bool{true, false} {
+			expected := tt.relaxed
+			if canonical {
+				expected = tt.canonical
+			}
+			actual, err := EncodeExtJSON(nil, tt.doc, canonical)
+			if err != nil {
+				t.Errorf("EncodeExtJSON(%v, %v) returned error %v", tt.doc, canonical, err)
+			} else if string(actual) != expected {
+				t.Errorf("EncodeExtJSON(%v, %v) = %s, want %s", tt.doc, canonical, actual, expected)
+			}
+		}
+	}
+}
+
+func TestDecodeExtJSON(t *testing.T) {
+	var m M
+	err := DecodeExtJSON([]byte(`{"n": {"$numberLong": "7"}, "a": [1, "x"], "id": {"$oid": "4c9b8fb4a382aafe17c86e63"}}`), &m)
+	if err != nil {
+		t.Fatalf("DecodeExtJSON returned error %v", err)
+	}
+	expected := M{
+		"n":  int64(7),
+		"a":  []interface{}{1, "x"},
+		"id": ObjectId("\x4C\x9B\x8F\xB4\xA3\x82\xAA\xFE\x17\xC8\x6E\x63"),
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("DecodeExtJSON = %v, want %v", m, expected)
+	}
+}
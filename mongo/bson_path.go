@@ -0,0 +1,525 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The path methods on D, M and Raw access elements by dotted path. Path
+// elements that follow an array are the index of an array element:
+//
+//  doc := mongo.M{"a": mongo.A{mongo.M{"b": 1}}}
+//  doc.Get("a.0.b") // returns 1
+//
+// The methods on D and M traverse values of type D, M, map[string]interface{},
+// A and []interface{}. Values of other types are not traversed.
+
+// PathError is returned when a path cannot be set because an element on the
+// path is not a document or array, or because an array index is not valid.
+type PathError struct {
+	Path   string
+	reason string
+}
+
+func (e *PathError) Error() string {
+	return "mongo: cannot set path " + strconv.Quote(e.Path) + ": " + e.reason
+}
+
+// Get returns the value at the dotted path in d or nil if the path does not
+// exist.
+func (d D) Get(path string) interface{} {
+	v, _ := getPath(d, path)
+	return v
+}
+
+// Has returns true if the dotted path exists in d.
+func (d D) Has(path string) bool {
+	_, found := getPath(d, path)
+	return found
+}
+
+// Set sets the value at the dotted path in d. If the path ends at an array,
+// then the index must be less than or equal to the length of the array. An
+// index equal to the length appends the value to the array. Missing documents
+// on the path are created with type D.
+func (d *D) Set(path string, value interface{}) error {
+	v, err := setPath(*d, path, strings.Split(path, "."), value, func() interface{} { return D{} })
+	if err != nil {
+		return err
+	}
+	*d = v.(D)
+	return nil
+}
+
+// Delete deletes the value at the dotted path in d. Array elements following
+// a deleted array element are shifted down by one. Delete does nothing if the
+// path does not exist.
+func (d *D) Delete(path string) {
+	*d = deletePath(*d, strings.Split(path, ".")).(D)
+}
+
+// Get returns the value at the dotted path in m or nil if the path does not
+// exist.
+func (m M) Get(path string) interface{} {
+	v, _ := getPath(m, path)
+	return v
+}
+
+// Has returns true if the dotted path exists in m.
+func (m M) Has(path string) bool {
+	_, found := getPath(m, path)
+	return found
+}
+
+// Set sets the value at the dotted path in m. Array indexes are handled as
+// described in the documentation for D.Set. Missing documents on the path are
+// created with type M.
+func (m M) Set(path string, value interface{}) error {
+	_, err := setPath(m, path, strings.Split(path, "."), value, func() interface{} { return M{} })
+	return err
+}
+
+// Delete deletes the value at the dotted path in m. Delete does nothing if
+// the path does not exist.
+func (m M) Delete(path string) {
+	deletePath(m, strings.Split(path, "."))
+}
+
+// M returns d converted to a map. Nested documents of type D are also
+// converted. If a name appears more than once in d, then the last value is
+// used.
+func (d D) M() M {
+	m := make(M, len(d))
+	for _, item := range d {
+		m[item.Key] = convertValue(item.Value, true)
+	}
+	return m
+}
+
+// D returns m converted to an ordered document with the keys in sorted
+// order. Nested documents of type M are also converted.
+func (m M) D() D {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	d := make(D, len(keys))
+	for i, k := range keys {
+		d[i] = DocItem{k, convertValue(m[k], false)}
+	}
+	return d
+}
+
+// Copy returns a deep copy of d. Copy copies values of type D, M,
+// map[string]interface{}, A, []interface{}, []byte, Raw and BSONData. Other
+// values are shared between d and the copy.
+func (d D) Copy() D {
+	if d == nil {
+		return nil
+	}
+	c := make(D, len(d))
+	for i, item := range d {
+		c[i] = DocItem{item.Key, copyValue(item.Value)}
+	}
+	return c
+}
+
+// Copy returns a deep copy of m. See D.Copy for the list of types that are
+// copied.
+func (m M) Copy() M {
+	if m == nil {
+		return nil
+	}
+	c := make(M, len(m))
+	for k, v := range m {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case D:
+		return v.Copy()
+	case M:
+		return v.Copy()
+	case map[string]interface{}:
+		return map[string]interface{}(M(v).Copy())
+	case A:
+		return A(copySlice(v))
+	case []interface{}:
+		return copySlice(v)
+	case []byte:
+		return append([]byte(nil), v...)
+	case Raw:
+		return append(Raw(nil), v...)
+	case BSONData:
+		return BSONData{v.Kind, append([]byte(nil), v.Data...)}
+	}
+	return v
+}
+
+func copySlice(s []interface{}) []interface{} {
+	if s == nil {
+		return nil
+	}
+	c := make([]interface{}, len(s))
+	for i, v := range s {
+		c[i] = copyValue(v)
+	}
+	return c
+}
+
+// convertValue converts nested D values to M if toM is true and nested M
+// values to D otherwise.
+func convertValue(v interface{}, toM bool) interface{} {
+	switch v := v.(type) {
+	case D:
+		if toM {
+			return v.M()
+		}
+		return v
+	case M:
+		if !toM {
+			return v.D()
+		}
+		return v
+	case map[string]interface{}:
+		if !toM {
+			return M(v).D()
+		}
+		return v
+	case A:
+		return A(convertSlice(v, toM))
+	case []interface{}:
+		return convertSlice(v, toM)
+	}
+	return v
+}
+
+func convertSlice(s []interface{}, toM bool) []interface{} {
+	if s == nil {
+		return nil
+	}
+	c := make([]interface{}, len(s))
+	for i, v := range s {
+		c[i] = convertValue(v, toM)
+	}
+	return c
+}
+
+// arrayIndex returns the index in key if key is a valid index for an array
+// of length n. Index n is valid if allowAppend is true.
+func arrayIndex(key string, n int, allowAppend bool) (int, bool) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i > n || (i == n && !allowAppend) {
+		return 0, false
+	}
+	return i, true
+}
+
+func getPath(v interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch c := v.(type) {
+		case D:
+			found := false
+			for _, item := range c {
+				if item.Key == key {
+					v, found = item.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case M:
+			var found bool
+			if v, found = c[key]; !found {
+				return nil, false
+			}
+		case map[string]interface{}:
+			var found bool
+			if v, found = c[key]; !found {
+				return nil, false
+			}
+		case A:
+			i, ok := arrayIndex(key, len(c), false)
+			if !ok {
+				return nil, false
+			}
+			v = c[i]
+		case []interface{}:
+			i, ok := arrayIndex(key, len(c), false)
+			if !ok {
+				return nil, false
+			}
+			v = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// setPath sets the value at keys in v and returns the updated v. A new value
+// is returned when an element is appended to a D or an array. Missing
+// documents on the path are created with newDoc.
+func setPath(v interface{}, path string, keys []string, value interface{}, newDoc func() interface{}) (interface{}, error) {
+	key, rest := keys[0], keys[1:]
+	set := func(old interface{}, found bool) (interface{}, error) {
+		if len(rest) == 0 {
+			return value, nil
+		}
+		if !found {
+			old = newDoc()
+		}
+		return setPath(old, path, rest, value, newDoc)
+	}
+	switch c := v.(type) {
+	case D:
+		for i, item := range c {
+			if item.Key == key {
+				x, err := set(item.Value, true)
+				if err != nil {
+					return nil, err
+				}
+				c[i].Value = x
+				return c, nil
+			}
+		}
+		x, err := set(nil, false)
+		if err != nil {
+			return nil, err
+		}
+		return append(c, DocItem{key, x}), nil
+	case M:
+		old, found := c[key]
+		x, err := set(old, found)
+		if err != nil {
+			return nil, err
+		}
+		c[key] = x
+		return c, nil
+	case map[string]interface{}:
+		old, found := c[key]
+		x, err := set(old, found)
+		if err != nil {
+			return nil, err
+		}
+		c[key] = x
+		return c, nil
+	case A:
+		x, err := setSlice(c, path, key, set)
+		return A(x), err
+	case []interface{}:
+		return setSlice(c, path, key, set)
+	}
+	return nil, &PathError{path, "element " + strconv.Quote(key) + " is not in a document or array"}
+}
+
+func setSlice(s []interface{}, path, key string, set func(interface{}, bool) (interface{}, error)) ([]interface{}, error) {
+	i, ok := arrayIndex(key, len(s), true)
+	if !ok {
+		return nil, &PathError{path, "bad array index " + strconv.Quote(key)}
+	}
+	if i == len(s) {
+		x, err := set(nil, false)
+		if err != nil {
+			return nil, err
+		}
+		return append(s, x), nil
+	}
+	x, err := set(s[i], true)
+	if err != nil {
+		return nil, err
+	}
+	s[i] = x
+	return s, nil
+}
+
+// deletePath deletes the value at keys in v and returns the updated v.
+func deletePath(v interface{}, keys []string) interface{} {
+	key, rest := keys[0], keys[1:]
+	switch c := v.(type) {
+	case D:
+		for i, item := range c {
+			if item.Key == key {
+				if len(rest) == 0 {
+					return append(c[:i:i], c[i+1:]...)
+				}
+				c[i].Value = deletePath(item.Value, rest)
+				return c
+			}
+		}
+	case M:
+		deleteMap(c, key, rest)
+	case map[string]interface{}:
+		deleteMap(c, key, rest)
+	case A:
+		return A(deleteSlice(c, key, rest))
+	case []interface{}:
+		return deleteSlice(c, key, rest)
+	}
+	return v
+}
+
+func deleteMap(m map[string]interface{}, key string, rest []string) {
+	if len(rest) == 0 {
+		delete(m, key)
+	} else if old, found := m[key]; found {
+		m[key] = deletePath(old, rest)
+	}
+}
+
+func deleteSlice(s []interface{}, key string, rest []string) []interface{} {
+	i, ok := arrayIndex(key, len(s), false)
+	if !ok {
+		return s
+	}
+	if len(rest) == 0 {
+		return append(s[:i:i], s[i+1:]...)
+	}
+	s[i] = deletePath(s[i], rest)
+	return s
+}
+
+// Has returns true if the dotted path exists in r. Use Lookup to get the
+// value at a path.
+func (r Raw) Has(path string) bool {
+	_, err := r.Lookup(path)
+	return err == nil
+}
+
+// Set returns a copy of r with the value at the dotted path set to value.
+// Array indexes are handled as described in the documentation for D.Set.
+// Missing documents on the path are created. The value is encoded as
+// described in the documentation for the Encode function. Set returns an
+// error if the value encodes to nothing, as a nil pointer does.
+func (r Raw) Set(path string, value interface{}) (result Raw, err error) {
+	defer handleAbort(&err)
+	e := encodeState{}
+	e.setRaw(r, false, path, strings.Split(path, "."), value)
+	return Raw(e.buffer), nil
+}
+
+// Delete returns a copy of r with the value at the dotted path deleted.
+// Array elements following a deleted array element are shifted down by one.
+func (r Raw) Delete(path string) (result Raw, err error) {
+	defer handleAbort(&err)
+	e := encodeState{}
+	e.deleteRaw(r, false, strings.Split(path, "."))
+	return Raw(e.buffer), nil
+}
+
+var emptyRawDoc = []byte{5, 0, 0, 0, 0}
+
+// setRaw writes document or array doc with the value at keys set to value.
+func (e *encodeState) setRaw(doc []byte, array bool, path string, keys []string, value interface{}) {
+	key, rest := keys[0], keys[1:]
+	set := func(name string, kind int, data []byte) {
+		switch {
+		case len(rest) == 0:
+			if value == nil {
+				e.writeKindName(kindNull, name)
+			} else {
+				n := len(e.buffer)
+				e.encodeValue(name, defaultFieldSpec, reflect.ValueOf(value))
+				if len(e.buffer) == n {
+					abort(&PathError{path, "value encodes to nothing"})
+				}
+			}
+		case kind == kindDocument || kind == kindArray:
+			e.writeKindName(kind, name)
+			e.setRaw(data, kind == kindArray, path, rest, value)
+		default:
+			abort(&PathError{path, "element " + strconv.Quote(rest[0]) + " is not in a document or array"})
+		}
+	}
+	d := decodeState{data: doc}
+	offset := d.beginValidDoc()
+	start := e.beginDoc()
+	found := false
+	n := 0
+	for ; ; n++ {
+		kind, name := d.scanKindName()
+		if kind == 0 {
+			break
+		}
+		vstart := d.offset
+		d.validateValue(kind)
+		data := doc[vstart:d.offset]
+		if !found && string(name) == key {
+			found = true
+			set(string(name), kind, data)
+		} else {
+			e.writeKindName(kind, string(name))
+			e.Write(data)
+		}
+	}
+	d.endDoc(offset)
+	if !found {
+		if array {
+			if key != strconv.Itoa(n) {
+				abort(&PathError{path, "bad array index " + strconv.Quote(key)})
+			}
+		}
+		set(key, kindDocument, emptyRawDoc)
+	}
+	e.WriteByte(0)
+	e.endDoc(start)
+}
+
+// deleteRaw writes document or array doc with the value at keys deleted.
+func (e *encodeState) deleteRaw(doc []byte, array bool, keys []string) {
+	key, rest := keys[0], keys[1:]
+	d := decodeState{data: doc}
+	offset := d.beginValidDoc()
+	start := e.beginDoc()
+	found := false
+	n := 0
+	for {
+		kind, name := d.scanKindName()
+		if kind == 0 {
+			break
+		}
+		vstart := d.offset
+		d.validateValue(kind)
+		data := doc[vstart:d.offset]
+		match := !found && string(name) == key
+		found = found || match
+		if match && len(rest) == 0 {
+			continue
+		}
+		if array {
+			// Renumber elements following a deleted element.
+			name = strconv.AppendInt(name[:0:0], int64(n), 10)
+		}
+		n++
+		e.writeKindName(kind, string(name))
+		if match && (kind == kindDocument || kind == kindArray) {
+			e.deleteRaw(data, kind == kindArray, rest)
+		} else {
+			e.Write(data)
+		}
+	}
+	d.endDoc(offset)
+	e.WriteByte(0)
+	e.endDoc(start)
+}
@@ -0,0 +1,235 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func pathTestDoc() D {
+	return D{
+		{"a", 1},
+		{"b", D{{"c", "x"}, {"d", A{10, M{"e": true}}}}},
+		{"f", M{"g": []interface{}{1, 2}}},
+	}
+}
+
+var getPathTests = []struct {
+	path  string
+	value interface{}
+	found bool
+}{
+	{"a", 1, true},
+	{"b.c", "x", true},
+	{"b.d.0", 10, true},
+	{"b.d.1.e", true, true},
+	{"f.g.1", 2, true},
+	{"x", nil, false},
+	{"a.b", nil, false},
+	{"b.d.2", nil, false},
+	{"b.d.-1", nil, false},
+	{"b.d.x", nil, false},
+	{"f.x", nil, false},
+}
+
+func TestGetPath(t *testing.T) {
+	d := pathTestDoc()
+	m := d.M()
+	r, err := Encode(nil, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range getPathTests {
+		if v := d.Get(tt.path); !reflect.DeepEqual(v, tt.value) {
+			t.Errorf("D.Get(%q) = %v, want %v", tt.path, v, tt.value)
+		}
+		if v := m.Get(tt.path); !reflect.DeepEqual(v, tt.value) {
+			t.Errorf("M.Get(%q) = %v, want %v", tt.path, v, tt.value)
+		}
+		if found := d.Has(tt.path); found != tt.found {
+			t.Errorf("D.Has(%q) = %v, want %v", tt.path, found, tt.found)
+		}
+		if found := m.Has(tt.path); found != tt.found {
+			t.Errorf("M.Has(%q) = %v, want %v", tt.path, found, tt.found)
+		}
+		if found := Raw(r).Has(tt.path); found != tt.found {
+			t.Errorf("Raw.Has(%q) = %v, want %v", tt.path, found, tt.found)
+		}
+	}
+}
+
+var setPathTests = []struct {
+	path     string
+	value    interface{}
+	expected D
+	err      bool
+}{
+	{"a", 2, D{{"a", 2}, {"b", D{{"c", "x"}, {"d", A{10, M{"e": true}}}}}, {"f", M{"g": []interface{}{1, 2}}}}, false},
+	{"b.c", "y", D{{"a", 1}, {"b", D{{"c", "y"}, {"d", A{10, M{"e": true}}}}}, {"f", M{"g": []interface{}{1, 2}}}}, false},
+	{"b.d.0", 11, D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{11, M{"e": true}}}}}, {"f", M{"g": []interface{}{1, 2}}}}, false},
+	{"b.d.2", 12, D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{10, M{"e": true}, 12}}}}, {"f", M{"g": []interface{}{1, 2}}}}, false},
+	{"b.d.1.e", false, D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{10, M{"e": false}}}}}, {"f", M{"g": []interface{}{1, 2}}}}, false},
+	{"x.y", 3, D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{10, M{"e": true}}}}}, {"f", M{"g": []interface{}{1, 2}}}, {"x", D{{"y", 3}}}}, false},
+	{"b.d.2.x", 5, D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{10, M{"e": true}, D{{"x", 5}}}}}}, {"f", M{"g": []interface{}{1, 2}}}}, false},
+	{"f.g.2", 3, D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{10, M{"e": true}}}}}, {"f", M{"g": []interface{}{1, 2, 3}}}}, false},
+	{"a.b", 3, nil, true},
+	{"b.d.3", 3, nil, true},
+	{"b.d.x", 3, nil, true},
+}
+
+func TestSetPath(t *testing.T) {
+	for _, tt := range setPathTests {
+		d := pathTestDoc()
+		err := d.Set(tt.path, tt.value)
+		if tt.err {
+			if _, ok := err.(*PathError); !ok {
+				t.Errorf("D.Set(%q) returned error %v, want PathError", tt.path, err)
+			}
+		} else if err != nil {
+			t.Errorf("D.Set(%q) returned error %v", tt.path, err)
+		} else if !reflect.DeepEqual(d, tt.expected) {
+			t.Errorf("D.Set(%q) = %v, want %v", tt.path, d, tt.expected)
+		}
+
+		m := pathTestDoc().M()
+		err = m.Set(tt.path, tt.value)
+		if tt.err {
+			if _, ok := err.(*PathError); !ok {
+				t.Errorf("M.Set(%q) returned error %v, want PathError", tt.path, err)
+			}
+		} else if err != nil {
+			t.Errorf("M.Set(%q) returned error %v", tt.path, err)
+		} else if expected := tt.expected.M(); !reflect.DeepEqual(m, expected) {
+			t.Errorf("M.Set(%q) = %v, want %v", tt.path, m, expected)
+		}
+
+		r, err := Encode(nil, pathTestDoc())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = Raw(r).Set(tt.path, tt.value)
+		if tt.err {
+			if _, ok := err.(*PathError); !ok {
+				t.Errorf("Raw.Set(%q) returned error %v, want PathError", tt.path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Raw.Set(%q) returned error %v", tt.path, err)
+			continue
+		}
+		expected, err := Encode(nil, tt.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(r, expected) {
+			t.Errorf("Raw.Set(%q) = %v, want %v", tt.path, r, expected)
+		}
+	}
+}
+
+func TestRawSetEmptyValue(t *testing.T) {
+	r, err := Encode(nil, pathTestDoc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a", "b.c", "x"} {
+		if _, err := Raw(r).Set(path, (*int)(nil)); err == nil {
+			t.Errorf("Raw.Set(%q, nil pointer) did not return an error", path)
+		}
+	}
+}
+
+var deletePathTests = []struct {
+	path     string
+	expected D
+}{
+	{"a", D{{"b", D{{"c", "x"}, {"d", A{10, M{"e": true}}}}}, {"f", M{"g": []interface{}{1, 2}}}}},
+	{"b.d.0", D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{M{"e": true}}}}}, {"f", M{"g": []interface{}{1, 2}}}}},
+	{"b.d.1.e", D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{10, M{}}}}}, {"f", M{"g": []interface{}{1, 2}}}}},
+	{"f.g.1", D{{"a", 1}, {"b", D{{"c", "x"}, {"d", A{10, M{"e": true}}}}}, {"f", M{"g": []interface{}{1}}}}},
+	{"x.y", pathTestDoc()},
+	{"b.d.5", pathTestDoc()},
+}
+
+func TestDeletePath(t *testing.T) {
+	for _, tt := range deletePathTests {
+		d := pathTestDoc()
+		d.Delete(tt.path)
+		if !reflect.DeepEqual(d, tt.expected) {
+			t.Errorf("D.Delete(%q) = %v, want %v", tt.path, d, tt.expected)
+		}
+
+		m := pathTestDoc().M()
+		m.Delete(tt.path)
+		if expected := tt.expected.M(); !reflect.DeepEqual(m, expected) {
+			t.Errorf("M.Delete(%q) = %v, want %v", tt.path, m, expected)
+		}
+
+		r, err := Encode(nil, pathTestDoc())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = Raw(r).Delete(tt.path)
+		if err != nil {
+			t.Errorf("Raw.Delete(%q) returned error %v", tt.path, err)
+			continue
+		}
+		expected, err := Encode(nil, tt.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(r, expected) {
+			t.Errorf("Raw.Delete(%q) = %v, want %v", tt.path, r, expected)
+		}
+	}
+}
+
+func TestConvertDoc(t *testing.T) {
+	d := D{{"b", 1}, {"a", D{{"c", A{D{{"d", 2}}}}}}}
+	m := d.M()
+	expectedM := M{"b": 1, "a": M{"c": A{M{"d": 2}}}}
+	if !reflect.DeepEqual(m, expectedM) {
+		t.Errorf("D.M() = %v, want %v", m, expectedM)
+	}
+	expectedD := D{{"a", D{{"c", A{D{{"d", 2}}}}}}, {"b", 1}}
+	if d := m.D(); !reflect.DeepEqual(d, expectedD) {
+		t.Errorf("M.D() = %v, want %v", d, expectedD)
+	}
+}
+
+func TestCopyDoc(t *testing.T) {
+	d := pathTestDoc()
+	d.Append("h", []byte("abc"))
+	c := d.Copy()
+	if !reflect.DeepEqual(c, d) {
+		t.Fatalf("D.Copy() = %v, want %v", c, d)
+	}
+	c.Set("b.d.1.e", false)
+	c.Set("f.g.0", 3)
+	c.Get("h").([]byte)[0] = 'x'
+	if expected := append(pathTestDoc(), DocItem{"h", []byte("abc")}); !reflect.DeepEqual(d, expected) {
+		t.Errorf("original modified by change to copy, got %v, want %v", d, expected)
+	}
+
+	m := pathTestDoc().M()
+	mc := m.Copy()
+	mc.Set("b.c", "y")
+	if expected := pathTestDoc().M(); !reflect.DeepEqual(m, expected) {
+		t.Errorf("original modified by change to copy, got %v, want %v", m, expected)
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Raw represents the BSON encoding of a document. The methods on Raw read
+// the encoding directly without decoding the entire document.
+//
+// Raw values encode and decode as documents. Decoding to a Raw value copies
+// the document data.
+type Raw []byte
+
+// RawElement represents an element in a document.
+type RawElement struct {
+	Name  string
+	Value BSONData
+}
+
+// Validate returns an error if r is not a valid BSON document.
+func (r Raw) Validate() (err error) {
+	defer handleAbort(&err)
+	d := decodeState{data: r}
+	d.validateValue(kindDocument)
+	if d.offset != len(r) {
+		return errors.New("bson: unexpected data after document")
+	}
+	return nil
+}
+
+// Decode decodes r to v. See the Decode function for more information about
+// BSON decoding.
+func (r Raw) Decode(v interface{}) error {
+	return Decode(r, v)
+}
+
+// Lookup returns the value at the dotted path in r. Path elements that
+// follow an array are the index of an array element. Lookup returns
+// ErrNotFound if the path does not exist in the document.
+//
+// The Data field of the returned value references the memory in r.
+func (r Raw) Lookup(path string) (value BSONData, err error) {
+	defer handleAbort(&err)
+	d := decodeState{data: r}
+	kind := kindDocument
+	for {
+		key := path
+		i := strings.IndexByte(path, '.')
+		if i >= 0 {
+			key, path = path[:i], path[i+1:]
+		}
+		if kind != kindDocument && kind != kindArray {
+			return BSONData{}, ErrNotFound
+		}
+		var found bool
+		kind, found = d.findElement(kind == kindArray, key)
+		if !found {
+			return BSONData{}, ErrNotFound
+		}
+		if i < 0 {
+			break
+		}
+	}
+	start := d.offset
+	d.validateValue(kind)
+	return BSONData{Kind: kind, Data: d.data[start:d.offset]}, nil
+}
+
+// Elements returns the elements in r. The Data field of the returned values
+// references the memory in r.
+func (r Raw) Elements() (elements []RawElement, err error) {
+	defer handleAbort(&err)
+	d := decodeState{data: r}
+	offset := d.beginValidDoc()
+	for {
+		kind, name := d.scanKindName()
+		if kind == 0 {
+			break
+		}
+		start := d.offset
+		d.validateValue(kind)
+		elements = append(elements, RawElement{string(name), BSONData{Kind: kind, Data: d.data[start:d.offset]}})
+	}
+	d.endDoc(offset)
+	return elements, nil
+}
+
+// findElement scans the document or array at the current offset for the
+// element with the given key. If the element is found, then the offset is
+// left at the element's value.
+func (d *decodeState) findElement(array bool, key string) (int, bool) {
+	index := -1
+	if array {
+		var err error
+		index, err = strconv.Atoi(key)
+		if err != nil {
+			return 0, false
+		}
+	}
+	offset := d.beginValidDoc()
+	for i := 0; ; i++ {
+		kind, name := d.scanKindName()
+		if kind == 0 {
+			break
+		}
+		if (array && i == index) || (!array && string(name) == key) {
+			return kind, true
+		}
+		d.validateValue(kind)
+	}
+	d.endDoc(offset)
+	return 0, false
+}
+
+// validateValue skips over the value at the current offset and aborts if
+// the value is not valid.
+func (d *decodeState) validateValue(kind int) {
+	switch kind {
+	case kindString, kindCode, kindSymbol:
+		d.scanValidString()
+	case kindDocument, kindArray:
+		offset := d.beginValidDoc()
+		for {
+			kind, _ := d.scanKindName()
+			if kind == 0 {
+				break
+			}
+			d.validateValue(kind)
+		}
+		d.endDoc(offset)
+	case kindBinary:
+		d.scanValidBinary()
+	case kindBool:
+		if d.scanByte() > 1 {
+			abort(errors.New("bson: bad boolean value"))
+		}
+	case kindRegexp:
+		d.scanCString()
+		d.scanCString()
+	case kindCodeWithScope:
+		start := d.offset
+		n := int(d.scanInt32())
+		if n < 14 || n > len(d.data)-start {
+			abort(errors.New("bson: bad code with scope length"))
+		}
+		d.scanValidString()
+		d.validateValue(kindDocument)
+		if d.offset != start+n {
+			abort(errors.New("bson: bad code with scope length"))
+		}
+	case kindObjectId:
+		d.scanSlice(12)
+	case kindDateTime, kindTimestamp, kindInt64, kindFloat:
+		d.scanSlice(8)
+	case kindInt32:
+		d.scanSlice(4)
+	case kindMinValue, kindMaxValue, kindNull:
+	default:
+		abort(&DecodeTypeError{kind})
+	}
+}
+
+func (bd BSONData) convertError(v interface{}) error {
+	return &DecodeConvertError{kind: bd.Kind, t: reflect.TypeOf(v)}
+}
+
+// FloatOK returns the value of a BSON double.
+func (bd BSONData) FloatOK() (float64, bool) {
+	if bd.Kind != kindFloat || len(bd.Data) != 8 {
+		return 0, false
+	}
+	return math.Float64frombits(wire.Uint64(bd.Data)), true
+}
+
+// Float returns the value of a BSON double. Float panics if the value is not a
+// double.
+func (bd BSONData) Float() float64 {
+	f, ok := bd.FloatOK()
+	if !ok {
+		panic(bd.convertError(f))
+	}
+	return f
+}
+
+// StringOK returns the value of a BSON string.
+func (bd BSONData) StringOK() (string, bool) {
+	if bd.Kind != kindString || len(bd.Data) < 5 || int(wire.Uint32(bd.Data)) != len(bd.Data)-4 {
+		return "", false
+	}
+	return string(bd.Data[4 : len(bd.Data)-1]), true
+}
+
+// DocumentOK returns the value of a BSON document.
+func (bd BSONData) DocumentOK() (Raw, bool) {
+	if bd.Kind != kindDocument {
+		return nil, false
+	}
+	return Raw(bd.Data), true
+}
+
+// Document returns the value of a BSON document. Document panics if the value
+// is not a document.
+func (bd BSONData) Document() Raw {
+	r, ok := bd.DocumentOK()
+	if !ok {
+		panic(bd.convertError(r))
+	}
+	return r
+}
+
+// ArrayOK returns the value of a BSON array. The elements of the array are
+// the elements of the returned document.
+func (bd BSONData) ArrayOK() (Raw, bool) {
+	if bd.Kind != kindArray {
+		return nil, false
+	}
+	return Raw(bd.Data), true
+}
+
+// Array returns the value of a BSON array. Array panics if the value is not an
+// array.
+func (bd BSONData) Array() Raw {
+	r, ok := bd.ArrayOK()
+	if !ok {
+		panic(bd.convertError(r))
+	}
+	return r
+}
+
+// BinaryOK returns the value of BSON binary data. The length prefix of the old
+// binary subtype 2 is removed from the returned slice. The returned slice
+// references the memory in bd.Data.
+func (bd BSONData) BinaryOK() ([]byte, bool) {
+	if bd.Kind != kindBinary || len(bd.Data) < 5 || int(wire.Uint32(bd.Data)) != len(bd.Data)-5 {
+		return nil, false
+	}
+	p := bd.Data[5:]
+	if bd.Data[4] == 2 {
+		if len(p) < 4 || int(wire.Uint32(p)) != len(p)-4 {
+			return nil, false
+		}
+		p = p[4:]
+	}
+	return p, true
+}
+
+// Binary returns the value of BSON binary data. Binary panics if the value is
+// not binary data.
+func (bd BSONData) Binary() []byte {
+	p, ok := bd.BinaryOK()
+	if !ok {
+		panic(bd.convertError(p))
+	}
+	return p
+}
+
+// ObjectIdOK returns the value of a BSON object id.
+func (bd BSONData) ObjectIdOK() (ObjectId, bool) {
+	if bd.Kind != kindObjectId || len(bd.Data) != 12 {
+		return "", false
+	}
+	return ObjectId(bd.Data), true
+}
+
+// ObjectId returns the value of a BSON object id. ObjectId panics if the value
+// is not an object id.
+func (bd BSONData) ObjectId() ObjectId {
+	id, ok := bd.ObjectIdOK()
+	if !ok {
+		panic(bd.convertError(id))
+	}
+	return id
+}
+
+// BoolOK returns the value of a BSON boolean.
+func (bd BSONData) BoolOK() (bool, bool) {
+	if bd.Kind != kindBool || len(bd.Data) != 1 {
+		return false, false
+	}
+	return bd.Data[0] != 0, true
+}
+
+// Bool returns the value of a BSON boolean. Bool panics if the value is not a
+// boolean.
+func (bd BSONData) Bool() bool {
+	b, ok := bd.BoolOK()
+	if !ok {
+		panic(bd.convertError(b))
+	}
+	return b
+}
+
+// TimeOK returns the value of a BSON datetime.
+func (bd BSONData) TimeOK() (time.Time, bool) {
+	if bd.Kind != kindDateTime || len(bd.Data) != 8 {
+		return time.Time{}, false
+	}
+	return timeFromMS(int64(wire.Uint64(bd.Data))), true
+}
+
+// Time returns the value of a BSON datetime. Time panics if the value is not a
+// datetime.
+func (bd BSONData) Time() time.Time {
+	t, ok := bd.TimeOK()
+	if !ok {
+		panic(bd.convertError(t))
+	}
+	return t
+}
+
+// Int32OK returns the value of a BSON 32-bit integer.
+func (bd BSONData) Int32OK() (int32, bool) {
+	if bd.Kind != kindInt32 || len(bd.Data) != 4 {
+		return 0, false
+	}
+	return int32(wire.Uint32(bd.Data)), true
+}
+
+// Int32 returns the value of a BSON 32-bit integer. Int32 panics if the value
+// is not a 32-bit integer.
+func (bd BSONData) Int32() int32 {
+	n, ok := bd.Int32OK()
+	if !ok {
+		panic(bd.convertError(n))
+	}
+	return n
+}
+
+// Int64OK returns the value of a BSON 32-bit or 64-bit integer.
+func (bd BSONData) Int64OK() (int64, bool) {
+	switch {
+	case bd.Kind == kindInt64 && len(bd.Data) == 8:
+		return int64(wire.Uint64(bd.Data)), true
+	case bd.Kind == kindInt32 && len(bd.Data) == 4:
+		return int64(int32(wire.Uint32(bd.Data))), true
+	}
+	return 0, false
+}
+
+// Int64 returns the value of a BSON 32-bit or 64-bit integer. Int64 panics if
+// the value is not an integer.
+func (bd BSONData) Int64() int64 {
+	n, ok := bd.Int64OK()
+	if !ok {
+		panic(bd.convertError(n))
+	}
+	return n
+}
+
+// TimestampOK returns the value of a BSON timestamp.
+func (bd BSONData) TimestampOK() (Timestamp, bool) {
+	if bd.Kind != kindTimestamp || len(bd.Data) != 8 {
+		return 0, false
+	}
+	return Timestamp(wire.Uint64(bd.Data)), true
+}
+
+// Timestamp returns the value of a BSON timestamp. Timestamp panics if the
+// value is not a timestamp.
+func (bd BSONData) Timestamp() Timestamp {
+	ts, ok := bd.TimestampOK()
+	if !ok {
+		panic(bd.convertError(ts))
+	}
+	return ts
+}
@@ -0,0 +1,219 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var rawTestDoc = D{
+	{"s", "hello"},
+	{"i", 42},
+	{"l", int64(1) << 40},
+	{"f", 1.5},
+	{"b", true},
+	{"t", time.Unix(1356351330, 501e6).UTC()},
+	{"id", ObjectId("\x4C\x9B\x8F\xB4\xA3\x82\xAA\xFE\x17\xC8\x6E\x63")},
+	{"bin", []byte("xyz")},
+	{"re", Regexp{"^a", "i"}},
+	{"code", Code("return 1")},
+	{"a", D{{"b", A{"x", D{{"c", "deep"}}}}}},
+}
+
+var rawLookupTests = []struct {
+	path     string
+	expected interface{}
+}{
+	{"s", "hello"},
+	{"i", int64(42)},
+	{"l", int64(1) << 40},
+	{"f", 1.5},
+	{"b", true},
+	{"t", time.Unix(1356351330, 501e6).UTC()},
+	{"id", ObjectId("\x4C\x9B\x8F\xB4\xA3\x82\xAA\xFE\x17\xC8\x6E\x63")},
+	{"bin", []byte("xyz")},
+	{"a.b.0", "x"},
+	{"a.b.1.c", "deep"},
+}
+
+func rawValue(bd BSONData) interface{} {
+	if s, ok := bd.StringOK(); ok {
+		return s
+	}
+	if n, ok := bd.Int64OK(); ok {
+		return n
+	}
+	if f, ok := bd.FloatOK(); ok {
+		return f
+	}
+	if b, ok := bd.BoolOK(); ok {
+		return b
+	}
+	if t, ok := bd.TimeOK(); ok {
+		return t
+	}
+	if id, ok := bd.ObjectIdOK(); ok {
+		return id
+	}
+	if p, ok := bd.BinaryOK(); ok {
+		return p
+	}
+	return bd
+}
+
+func TestRawLookup(t *testing.T) {
+	p, err := Encode(nil, rawTestDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Raw(p)
+	if err := r.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	for _, tt := range rawLookupTests {
+		bd, err := r.Lookup(tt.path)
+		if err != nil {
+			t.Errorf("Lookup(%q) returned error %v", tt.path, err)
+			continue
+		}
+		if v := rawValue(bd); !reflect.DeepEqual(v, tt.expected) {
+			t.Errorf("Lookup(%q) = %v, want %v", tt.path, v, tt.expected)
+		}
+	}
+	for _, path := range []string{"x", "s.x", "a.b.2", "a.b.x", "a.c", ""} {
+		if _, err := r.Lookup(path); err != ErrNotFound {
+			t.Errorf("Lookup(%q) = %v, want ErrNotFound", path, err)
+		}
+	}
+}
+
+func TestRawElements(t *testing.T) {
+	p, err := Encode(nil, rawTestDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements, err := Raw(p).Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != len(rawTestDoc) {
+		t.Fatalf("len(elements) = %d, want %d", len(elements), len(rawTestDoc))
+	}
+	for i, e := range elements {
+		if e.Name != rawTestDoc[i].Key {
+			t.Errorf("elements[%d].Name = %q, want %q", i, e.Name, rawTestDoc[i].Key)
+		}
+	}
+	if s, ok := elements[0].Value.StringOK(); !ok || s != "hello" {
+		t.Errorf("elements[0].Value.StringOK() = %q, %v, want \"hello\", true", s, ok)
+	}
+	if n := elements[1].Value.Int32(); n != 42 {
+		t.Errorf("elements[1].Value.Int32() = %d, want 42", n)
+	}
+	if _, ok := elements[1].Value.StringOK(); ok {
+		t.Error("StringOK() on int32 returned ok")
+	}
+	var m M
+	if err := elements[10].Value.Document().Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, M{"b": []interface{}{"x", map[string]interface{}{"c": "deep"}}}) {
+		t.Errorf("Document().Decode() = %v", m)
+	}
+}
+
+func TestRawPanic(t *testing.T) {
+	defer func() {
+		if _, ok := recover().(*DecodeConvertError); !ok {
+			t.Error("Document() on string did not panic with DecodeConvertError")
+		}
+	}()
+	p, _ := Encode(nil, M{"s": "hello"})
+	bd, _ := Raw(p).Lookup("s")
+	bd.Document()
+}
+
+var rawValidateTests = []string{
+	"\x04\x00\x00\x00\x00",
+	"\x05\x00\x00\x00",
+	"\x05\x00\x00\x00\x01",
+	"\x06\x00\x00\x00\x00\x00",
+	"\x0c\x00\x00\x00\x02a\x00\xff\xff\xff\xff\x00",
+	"\x0e\x00\x00\x00\x02a\x00\x02\x00\x00\x00\xe9\x00\x00",
+	"\x09\x00\x00\x00\x08b\x00\x02\x00",
+	"\x0d\x00\x00\x00\x10x\x00\x00\x01\x00\x00\x00\x00",
+}
+
+func TestRawValidate(t *testing.T) {
+	for _, data := range rawValidateTests {
+		if err := Raw(data).Validate(); err == nil {
+			t.Errorf("Validate(%q) did not return an error", data)
+		}
+	}
+}
+
+func TestRawEncodeDecode(t *testing.T) {
+	inner, _ := Encode(nil, M{"x": 1})
+	var v struct {
+		R Raw `bson:"r"`
+	}
+	v.R = Raw(inner)
+	p, err := Encode(nil, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Encode(nil, M{"r": M{"x": 1}})
+	if string(p) != string(expected) {
+		t.Fatalf("Encode() = %q, want %q", p, expected)
+	}
+	v.R = nil
+	if err := Decode(p, &v); err != nil {
+		t.Fatal(err)
+	}
+	if string(v.R) != string(inner) {
+		t.Fatalf("Decode() = %q, want %q", v.R, inner)
+	}
+	var r Raw
+	if err := Decode(p, &r); err != nil {
+		t.Fatal(err)
+	}
+	if string(r) != string(p) {
+		t.Fatalf("Decode() = %q, want %q", r, p)
+	}
+}
+
+var binaryOKTests = []struct {
+	data     string
+	expected []byte
+	ok       bool
+}{
+	{"\x04\x00\x00\x00\x00test", []byte("test"), true},
+	{"\x08\x00\x00\x00\x02\x04\x00\x00\x00test", []byte("test"), true},
+	{"\x08\x00\x00\x00\x02\x05\x00\x00\x00test", nil, false},
+	{"\x02\x00\x00\x00\x02ab", nil, false},
+	{"\x05\x00\x00\x00\x00test", nil, false},
+}
+
+func TestBinaryOK(t *testing.T) {
+	for _, tt := range binaryOKTests {
+		p, ok := BSONData{Kind: kindBinary, Data: []byte(tt.data)}.BinaryOK()
+		if ok != tt.ok || !bytes.Equal(p, tt.expected) {
+			t.Errorf("BinaryOK(%q) = %q, %v, want %q, %v", tt.data, p, ok, tt.expected, tt.ok)
+		}
+	}
+}
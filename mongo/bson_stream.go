@@ -0,0 +1,149 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// DefaultMaxDocumentSize is the default maximum size of a document read by a
+// Reader. The value is the maximum document size supported by the server.
+const DefaultMaxDocumentSize = 16 * 1024 * 1024
+
+// docSize returns the size of the document with length prefix p. An error is
+// returned if the size is less than the size of an empty document or greater
+// than maxSize.
+func docSize(p []byte, maxSize int) (int, error) {
+	n := int(int32(wire.Uint32(p)))
+	if n < 5 || n > maxSize {
+		return 0, errors.New("bson: document size " + strconv.Itoa(n) + " out of range")
+	}
+	return n, nil
+}
+
+// Reader reads a stream of concatenated BSON documents. The .bson files
+// written by the mongodump command use this format.
+//
+// An example use of a reader is:
+//
+//  r := mongo.NewReader(f)
+//  for {
+//      var m mongo.M
+//      err := r.ReadDoc(&m)
+//      if err == io.EOF {
+//          break
+//      }
+//      if err != nil {
+//          return err
+//      }
+//      // Do something with document m.
+//  }
+type Reader struct {
+	// Documents larger than MaxDocumentSize are rejected with an error. If
+	// zero, then DefaultMaxDocumentSize is used.
+	MaxDocumentSize int
+
+	br  *bufio.Reader
+	buf []byte
+	err error
+}
+
+// NewReader returns a reader that reads documents from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+func (r *Reader) fatal(err error) error {
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// ReadRaw reads the next document from the stream. The returned document is
+// only valid until the next call to ReadRaw or ReadDoc. ReadRaw returns io.EOF
+// when there are no more documents in the stream.
+func (r *Reader) ReadRaw() (Raw, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	p, err := r.br.Peek(4)
+	if err != nil {
+		if err == io.EOF && len(p) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, r.fatal(err)
+	}
+	maxSize := r.MaxDocumentSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxDocumentSize
+	}
+	n, err := docSize(p, maxSize)
+	if err != nil {
+		return nil, r.fatal(err)
+	}
+	if n > cap(r.buf) {
+		r.buf = make([]byte, n)
+	}
+	r.buf = r.buf[:n]
+	if _, err := io.ReadFull(r.br, r.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, r.fatal(err)
+	}
+	return Raw(r.buf), nil
+}
+
+// ReadDoc reads the next document from the stream and decodes it to v. See
+// the Decode function for more information about BSON decoding. ReadDoc
+// returns io.EOF when there are no more documents in the stream.
+func (r *Reader) ReadDoc(v interface{}) error {
+	p, err := r.ReadRaw()
+	if err != nil {
+		return err
+	}
+	return Decode(p, v)
+}
+
+// Writer writes a stream of concatenated BSON documents. The stream can
+// be read by a Reader or the mongorestore command.
+//
+// Writer issues one write to the underlying writer per document. Use a
+// bufio.Writer to reduce the number of writes.
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter returns a writer that writes documents to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteDoc encodes doc and writes it to the stream. The argument doc is any
+// value accepted by Encode.
+func (w *Writer) WriteDoc(doc interface{}) error {
+	p, err := Encode(w.buf[:0], doc)
+	if err != nil {
+		return err
+	}
+	w.buf = p
+	_, err = w.w.Write(p)
+	return err
+}
@@ -0,0 +1,77 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBSONStream(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i := 0; i < 100; i++ {
+		if err := w.WriteDoc(M{"x": i, "s": strings.Repeat("a", i*100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(&buf)
+	for i := 0; ; i++ {
+		var m M
+		err := r.ReadDoc(&m)
+		if err == io.EOF {
+			if i != 100 {
+				t.Fatalf("read %d documents, want 100", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m["x"] != i || len(m["s"].(string)) != i*100 {
+			t.Fatalf("document %d = %v", i, m)
+		}
+	}
+	if _, err := r.ReadRaw(); err != io.EOF {
+		t.Fatalf("ReadRaw() after end returned %v, want io.EOF", err)
+	}
+}
+
+var bsonStreamErrorTests = []struct {
+	data    string
+	maxSize int
+}{
+	{"\x05\x00", 0},
+	{"\x05\x00\x00\x00", 0},
+	{"\x04\x00\x00\x00\x00", 0},
+	{"\x0f\x00\x00\x00\x10test\x00\x0A\x00\x00\x00\x00", 10},
+}
+
+func TestBSONStreamErrors(t *testing.T) {
+	for _, tt := range bsonStreamErrorTests {
+		r := NewReader(strings.NewReader("\x05\x00\x00\x00\x00" + tt.data))
+		r.MaxDocumentSize = tt.maxSize
+		if _, err := r.ReadRaw(); err != nil {
+			t.Errorf("%q: first ReadRaw() returned error %v", tt.data, err)
+			continue
+		}
+		if _, err := r.ReadRaw(); err == nil || err == io.EOF {
+			t.Errorf("%q: second ReadRaw() returned %v, want error", tt.data, err)
+		}
+	}
+}
@@ -16,8 +16,10 @@ package mongo
 
 import (
 	"bytes"
+	"errors"
 	"math"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -628,7 +630,62 @@ func TestObjectId(t *testing.T) {
 	}
 	t2 := ObjectId("").CreationTime()
 	if !t2.IsZero() {
-		t.Error("creation time for invalid id = %d, want 0", t1)
+		t.Errorf("creation time for invalid id = %v, want zero time", t2)
+	}
+	if !id.IsValid() || ObjectId("").IsValid() || ObjectId("abc").IsValid() {
+		t.Error("IsValid returned wrong result")
+	}
+	if id.Timestamp().Before(t1.Truncate(time.Second)) || id.Timestamp().After(time.Now()) {
+		t.Errorf("id.Timestamp() = %v, want approximately %v", id.Timestamp(), t1)
+	}
+}
+
+func TestObjectIdCounter(t *testing.T) {
+	id1 := NewObjectId()
+	id2 := NewObjectId()
+	if id1[4:9] != id2[4:9] {
+		t.Errorf("process bytes differ, %s and %s", id1, id2)
+	}
+	c1 := int(id1[9])<<16 | int(id1[10])<<8 | int(id1[11])
+	c2 := int(id2[9])<<16 | int(id2[10])<<8 | int(id2[11])
+	if c2 != (c1+1)&0xffffff {
+		t.Errorf("counter %d followed by %d, want increment", c1, c2)
+	}
+}
+
+var objectIdTextTests = []struct {
+	id   ObjectId
+	text string
+	ok   bool
+}{
+	{ObjectId("\x4c\x9b\x8f\xb4\xa3\x82\xaa\xfe\x17\xc8\x6e\x63"), "4c9b8fb4a382aafe17c86e63", true},
+	{ObjectId(""), "", true},
+	{ObjectId("abc"), "", false},
+}
+
+func TestObjectIdText(t *testing.T) {
+	for _, tt := range objectIdTextTests {
+		text, err := tt.id.MarshalText()
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%q.MarshalText() did not return error", string(tt.id))
+			}
+			continue
+		}
+		if err != nil || string(text) != tt.text {
+			t.Errorf("%q.MarshalText() = %q, %v, want %q", string(tt.id), text, err, tt.text)
+		}
+		var id ObjectId
+		if err := id.UnmarshalText([]byte(tt.text)); err != nil || id != tt.id {
+			t.Errorf("UnmarshalText(%q) = %q, %v, want %q", tt.text, string(id), err, string(tt.id))
+		}
+	}
+	var id ObjectId
+	if err := id.UnmarshalText([]byte("xyz")); err == nil {
+		t.Error("UnmarshalText(xyz) did not return error")
+	}
+	if s := objectIdTextTests[0].id.Hex(); s != objectIdTextTests[0].text {
+		t.Errorf("Hex() = %q, want %q", s, objectIdTextTests[0].text)
 	}
 }
 
@@ -719,3 +776,641 @@ func TestStructFields(t *testing.T) {
 		}
 	}
 }
+
+func TestStructFieldInfo(t *testing.T) {
+	fields, inlineMap := StructFieldInfo(reflect.TypeOf(stEmbedPtr{}))
+	if inlineMap {
+		t.Error("inlineMap = true, want false")
+	}
+	for _, f := range fields {
+		if ft := reflect.TypeOf(stEmbedPtr{}).FieldByIndex(f.Index).Type; ft != f.Type {
+			t.Errorf("field %s has type %v, want %v", f.Name, f.Type, ft)
+		}
+	}
+	if len(fields) == 0 || fields[0].Name != "_id" || fields[0].Type != reflect.TypeOf(0) {
+		t.Errorf("fields = %+v, want _id int first", fields)
+	}
+}
+
+type stInline struct {
+	Id    int     `bson:"_id"`
+	Inner stInt32 `bson:",inline"`
+	Extra M       `bson:",inline"`
+}
+
+var structTagTests = []struct {
+	sv interface{}
+	m  D
+}{
+	{struct {
+		N int64 `bson:"n,minsize"`
+	}{1}, D{{"n", int32(1)}}},
+	{struct {
+		N int64 `bson:"n,minsize"`
+	}{1 << 40}, D{{"n", int64(1 << 40)}}},
+	{struct {
+		N uint64 `bson:"n,minsize"`
+	}{1}, D{{"n", int32(1)}}},
+	{struct {
+		N int  `bson:"n,string"`
+		F bool `bson:"f,string"`
+	}{42, true}, D{{"n", "42"}, {"f", "true"}}},
+	{struct {
+		A int `bson:"-"`
+		B int `bson:"b"`
+	}{0, 2}, D{{"b", 2}}},
+	{stInline{1, stInt32{2}, M{"x": "y"}}, D{{"_id", 1}, {"test", 2}, {"x", "y"}}},
+}
+
+func TestStructTagOptions(t *testing.T) {
+	for _, tt := range structTagTests {
+		data, err := Encode(nil, tt.sv)
+		if err != nil {
+			t.Errorf("Encode(%+v) returned error %v", tt.sv, err)
+			continue
+		}
+		expected, _ := Encode(nil, tt.m)
+		if string(data) != string(expected) {
+			t.Errorf("Encode(%+v) = %q, want %q", tt.sv, data, expected)
+		}
+		psv := reflect.New(reflect.TypeOf(tt.sv))
+		if err := Decode(data, psv.Interface()); err != nil {
+			t.Errorf("Decode(%q) returned error %v", data, err)
+		} else if sv := psv.Elem().Interface(); !reflect.DeepEqual(sv, tt.sv) {
+			t.Errorf("Decode(%q) = %+v, want %+v", data, sv, tt.sv)
+		}
+	}
+}
+
+func TestInlineMapConflict(t *testing.T) {
+	_, err := Encode(nil, stInline{Extra: M{"test": 1}})
+	if err == nil {
+		t.Error("Encode with conflicting inline map key did not return an error")
+	}
+}
+
+func TestDecodeRequired(t *testing.T) {
+	var v struct {
+		A int `bson:"a,required"`
+		B int `bson:"b,required"`
+	}
+	data, _ := Encode(nil, M{"a": 1, "b": nil})
+	err := Decode(data, &v)
+	if e, ok := err.(*DecodeRequiredError); !ok || e.Path != "b" {
+		t.Errorf("Decode() returned %v, want required error for b", err)
+	}
+	data, _ = Encode(nil, M{"a": 1, "b": 2})
+	if err := Decode(data, &v); err != nil {
+		t.Errorf("Decode() returned %v", err)
+	}
+}
+
+func TestDecodeTruncate(t *testing.T) {
+	data, _ := Encode(nil, M{"n": 1.5, "t": 2.5})
+	var v struct {
+		N int `bson:"n"`
+		T int `bson:"t,truncate"`
+	}
+	if _, ok := Decode(data, &v).(*DecodeConvertError); !ok {
+		t.Errorf("Decode() of 1.5 to int did not return convert error")
+	}
+	if v.T != 2 {
+		t.Errorf("v.T = %d, want 2", v.T)
+	}
+
+	for _, f := range []float64{1e30, -1e30, math.NaN(), math.Inf(1), math.Inf(-1), 1 << 63} {
+		data, _ := Encode(nil, M{"i": f})
+		var v struct {
+			I int64 `bson:"i,truncate"`
+		}
+		if _, ok := Decode(data, &v).(*DecodeConvertError); !ok || v.I != 0 {
+			t.Errorf("Decode() of %v to truncated int64 = %d, want convert error", f, v.I)
+		}
+	}
+	for _, f := range []float64{-1, -1e30, math.NaN(), 1 << 64} {
+		data, _ := Encode(nil, M{"u": f})
+		var v struct {
+			U uint64 `bson:"u,truncate"`
+		}
+		if _, ok := Decode(data, &v).(*DecodeConvertError); !ok || v.U != 0 {
+			t.Errorf("Decode() of %v to truncated uint64 = %d, want convert error", f, v.U)
+		}
+	}
+}
+
+func TestMinSizeUnsupported(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Encode() of int16 field with minsize option did not panic")
+		}
+	}()
+	Encode(nil, struct {
+		N int16 `bson:"n,minsize"`
+	}{1})
+}
+
+type stOrder struct {
+	Items []struct {
+		Price int `bson:"price"`
+	} `bson:"items"`
+}
+
+var decodeErrorPathTests = []struct {
+	doc  interface{}
+	v    interface{}
+	path string
+}{
+	{M{"test": "x"}, &stInt{}, "test"},
+	{M{"orders": A{M{}, M{"items": A{M{"price": "x"}}}}}, &struct {
+		Orders []stOrder `bson:"orders"`
+	}{}, "orders[1].items[0].price"},
+	{M{"m": M{"a": A{1, "x"}}}, &struct {
+		M map[string][2]int `bson:"m"`
+	}{}, "m.a[1]"},
+	{M{"s": M{}}, &struct {
+		S struct {
+			R int `bson:"r,required"`
+		} `bson:"s"`
+	}{}, "s.r"},
+}
+
+func TestDecodeErrorPath(t *testing.T) {
+	for _, tt := range decodeErrorPathTests {
+		data, _ := Encode(nil, tt.doc)
+		err := Decode(data, tt.v)
+		var path string
+		switch e := err.(type) {
+		case *DecodeConvertError:
+			path = e.Path
+		case *DecodeRequiredError:
+			path = e.Path
+		default:
+			t.Errorf("Decode(%v) returned %v, want error with path", tt.doc, err)
+			continue
+		}
+		if path != tt.path {
+			t.Errorf("Decode(%v) returned error with path %q, want %q", tt.doc, path, tt.path)
+		}
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	data, _ := Encode(nil, M{"items": A{M{"price": 1, "qty": 2}}})
+	var v stOrder
+	if err := Decode(data, &v); err != nil {
+		t.Errorf("Decode() returned error %v", err)
+	}
+	err := DecodeStrict(data, &v)
+	if e, ok := err.(*DecodeUnknownFieldError); !ok || e.Path != "items[0].qty" {
+		t.Errorf("DecodeStrict() returned %v, want unknown field error for items[0].qty", err)
+	}
+	if v.Items[0].Price != 1 {
+		t.Errorf("v.Items[0].Price = %d, want 1", v.Items[0].Price)
+	}
+	var s stInline
+	data, _ = Encode(nil, M{"_id": 1, "x": 2})
+	if err := DecodeStrict(data, &s); err != nil {
+		t.Errorf("DecodeStrict() with inline map returned error %v", err)
+	}
+}
+
+type stAudit struct {
+	Created int `bson:"created"`
+}
+
+type stEmbedPtr struct {
+	Id int `bson:"_id"`
+	*stAudit
+	*StRecursive
+}
+
+type StRecursive struct {
+	*StRecursive
+	Name string `bson:"name,omitempty"`
+}
+
+func TestEmbedPtr(t *testing.T) {
+	data, err := Encode(nil, stEmbedPtr{Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Encode(nil, D{{"_id", 1}})
+	if string(data) != string(expected) {
+		t.Errorf("Encode() with nil pointers = %q, want %q", data, expected)
+	}
+
+	var v stEmbedPtr
+	if err := Decode(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.StRecursive != nil || v.stAudit != nil {
+		t.Errorf("Decode() allocated embedded pointer for missing fields: %+v", v)
+	}
+
+	data, _ = Encode(nil, D{{"_id", 1}, {"name", "x"}})
+	if err := Decode(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.StRecursive == nil || v.Name != "x" {
+		t.Errorf("Decode() = %+v, want name x", v)
+	}
+	p, err := Encode(nil, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != string(data) {
+		t.Errorf("Encode() = %q, want %q", p, data)
+	}
+}
+
+type stUnexportedEmbed struct {
+	Id int `bson:"_id"`
+	stAudit
+	*stInt32
+}
+
+func TestUnexportedEmbed(t *testing.T) {
+	// The exported fields of an unexported embedded struct are encoded
+	// in-line. Unexported embedded pointers are ignored.
+	v := stUnexportedEmbed{Id: 1, stAudit: stAudit{Created: 2}, stInt32: &stInt32{3}}
+	data, err := Encode(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Encode(nil, D{{"_id", 1}, {"created", 2}})
+	if string(data) != string(expected) {
+		t.Errorf("Encode(%+v) = %q, want %q", v, data, expected)
+	}
+
+	data, _ = Encode(nil, D{{"_id", 1}, {"created", 2}, {"test", 3}})
+	var actual stUnexportedEmbed
+	if err := Decode(data, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.Id != 1 || actual.Created != 2 || actual.stInt32 != nil {
+		t.Errorf("Decode() = %+v, want _id 1, created 2 and nil stInt32", actual)
+	}
+}
+
+type stMarshaler struct {
+	Name string
+}
+
+func (v *stMarshaler) MarshalBSON(buf []byte) ([]byte, error) {
+	buf, start := AppendDocumentStart(buf)
+	buf = AppendString(buf, "marshaled", v.Name)
+	return AppendDocumentEnd(buf, start), nil
+}
+
+func TestBSONMarshaler(t *testing.T) {
+	v := stMarshaler{"x"}
+	p, err := Encode(nil, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Encode(nil, D{{"marshaled", "x"}})
+	if string(p) != string(expected) {
+		t.Errorf("Encode(&v) = %q, want %q", p, expected)
+	}
+
+	// Value in interface is not addressable.
+	p, err = Encode(nil, D{{"v", v}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ = Encode(nil, D{{"v", D{{"marshaled", "x"}}}})
+	if string(p) != string(expected) {
+		t.Errorf("Encode(D{{v, v}}) = %q, want %q", p, expected)
+	}
+}
+
+var appendTests = []struct {
+	append   func([]byte) []byte
+	expected interface{}
+}{
+	{func(p []byte) []byte { return AppendString(p, "x", "hello") }, "hello"},
+	{func(p []byte) []byte { return AppendInt32(p, "x", 1) }, int32(1)},
+	{func(p []byte) []byte { return AppendInt64(p, "x", 1) }, int64(1)},
+	{func(p []byte) []byte { return AppendInt(p, "x", 1) }, 1},
+	{func(p []byte) []byte { return AppendInt(p, "x", 1<<40) }, 1 << 40},
+	{func(p []byte) []byte { return AppendFloat(p, "x", 1.5) }, 1.5},
+	{func(p []byte) []byte { return AppendBool(p, "x", true) }, true},
+	{func(p []byte) []byte { return AppendTime(p, "x", time.Unix(1, 0)) }, time.Unix(1, 0)},
+	{func(p []byte) []byte { p, _ = AppendElement(p, "x", A{1, 2}, false); return p }, A{1, 2}},
+}
+
+func TestAppend(t *testing.T) {
+	for i, tt := range appendTests {
+		p, start := AppendDocumentStart([]byte("prefix"))
+		p = AppendDocumentEnd(tt.append(p), start)
+		expected, _ := Encode([]byte("prefix"), D{{"x", tt.expected}})
+		if string(p) != string(expected) {
+			t.Errorf("%d: got %q, want %q", i, p, expected)
+		}
+	}
+	p, err := AppendElement(nil, "x", 0, true)
+	if err != nil || len(p) != 0 {
+		t.Errorf("AppendElement(0, omitEmpty) = %q, %v, want empty", p, err)
+	}
+}
+
+type benchItem struct {
+	Sku      string  `bson:"sku"`
+	Quantity int     `bson:"quantity"`
+	Price    float64 `bson:"price"`
+}
+
+type benchOrder struct {
+	Id        ObjectId    `bson:"_id"`
+	Customer  string      `bson:"customer"`
+	Email     string      `bson:"email"`
+	Street    string      `bson:"street"`
+	City      string      `bson:"city"`
+	Zip       string      `bson:"zip"`
+	Country   string      `bson:"country"`
+	Status    string      `bson:"status"`
+	Priority  int         `bson:"priority"`
+	Total     float64     `bson:"total"`
+	Discount  float64     `bson:"discount,omitempty"`
+	Paid      bool        `bson:"paid"`
+	Shipped   bool        `bson:"shipped"`
+	Version   int64       `bson:"version"`
+	Created   time.Time   `bson:"created"`
+	Updated   time.Time   `bson:"updated"`
+	Tags      []string    `bson:"tags"`
+	Items     []benchItem `bson:"items"`
+	Notes     string      `bson:"notes,omitempty"`
+	Reference string      `bson:"reference"`
+}
+
+func newBenchOrder() *benchOrder {
+	o := &benchOrder{
+		Id:        NewObjectId(),
+		Customer:  "Jane Doe",
+		Email:     "jane@example.com",
+		Street:    "1 Main Street",
+		City:      "Springfield",
+		Zip:       "12345",
+		Country:   "US",
+		Status:    "open",
+		Priority:  2,
+		Total:     123.45,
+		Paid:      true,
+		Version:   7,
+		Created:   time.Unix(1356351330, 0),
+		Updated:   time.Unix(1356351330, 0),
+		Tags:      []string{"gift", "express"},
+		Reference: "ref-0001",
+	}
+	for i := 0; i < 10; i++ {
+		o.Items = append(o.Items, benchItem{"sku-" + strconv.Itoa(i), i, 9.99})
+	}
+	return o
+}
+
+func BenchmarkEncodeStruct(b *testing.B) {
+	o := newBenchOrder()
+	var p []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		p, err = Encode(p[:0], o)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(len(p)))
+}
+
+func BenchmarkDecodeStruct(b *testing.B) {
+	p, err := Encode(nil, newBenchOrder())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		var o benchOrder
+		if err := Decode(p, &o); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeMap(b *testing.B) {
+	p, err := Encode(nil, newBenchOrder())
+	if err != nil {
+		b.Fatal(err)
+	}
+	var m M
+	if err := Decode(p, &m); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if p, err = Encode(p[:0], m); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(len(p)))
+}
+
+type stCycle struct {
+	Next *stCycle `bson:"a"`
+}
+
+func TestEncodeCycle(t *testing.T) {
+	p := &stCycle{}
+	p.Next = &stCycle{p}
+	m := M{}
+	m["m"] = m
+	a := A{nil}
+	a[0] = a
+	var x interface{}
+	x = &x
+	for _, v := range []interface{}{p, m, D{{"a", a}}, M{"a": x}} {
+		_, err := Encode(nil, v)
+		if _, ok := err.(*EncodeCycleError); !ok {
+			t.Errorf("Encode(%T) returned %v, want cycle error", v, err)
+		}
+	}
+}
+
+type stFieldPtr struct {
+	N int  `bson:"n"`
+	P *int `bson:"p"`
+}
+
+func TestEncodeSameAddress(t *testing.T) {
+	// A pointer to a struct and a pointer to the struct's first field have the
+	// same address, but do not form a cycle.
+	a := &stFieldPtr{N: 1}
+	a.P = &a.N
+	var v interface{} = a
+	for i := 0; i < 40; i++ {
+		v = M{"a": v}
+	}
+	if _, err := Encode(nil, v); err != nil {
+		t.Errorf("Encode() returned %v", err)
+	}
+}
+
+// nestedDoc returns the encoding of a document with n levels of nested
+// documents.
+func nestedDoc(n int) []byte {
+	var p []byte
+	for i := 0; i < n; i++ {
+		// size, kind, name "a", value, terminator
+		head := []byte{0, 0, 0, 0, kindDocument, 'a', 0}
+		if i == 0 {
+			head = head[:4]
+		}
+		p = append(append(head, p...), 0)
+		wire.PutUint32(p, uint32(len(p)))
+	}
+	return p
+}
+
+func TestMaxDepth(t *testing.T) {
+	var p *stCycle
+	for i := 0; i < MaxNestingDepth+1; i++ {
+		p = &stCycle{p}
+	}
+	if _, err := Encode(nil, p); err != ErrMaxDepth {
+		t.Errorf("Encode(deep) returned %v, want ErrMaxDepth", err)
+	}
+	if _, err := Encode(nil, p.Next); err != nil {
+		t.Errorf("Encode(max depth) returned %v", err)
+	}
+
+	data := nestedDoc(MaxNestingDepth)
+	var m M
+	if err := Decode(data, &m); err != nil {
+		t.Errorf("Decode(max depth) returned %v", err)
+	}
+	data = nestedDoc(MaxNestingDepth + 1)
+	if err := Decode(data, &m); err != ErrMaxDepth {
+		t.Errorf("Decode(deep) returned %v, want ErrMaxDepth", err)
+	}
+	var v stCycle
+	if err := Decode(data, &v); err != ErrMaxDepth {
+		t.Errorf("Decode(deep, &stCycle) returned %v, want ErrMaxDepth", err)
+	}
+	if err := Raw(data).Validate(); err != ErrMaxDepth {
+		t.Errorf("Validate(deep) returned %v, want ErrMaxDepth", err)
+	}
+}
+
+var keyCheckTests = []struct {
+	doc  interface{}
+	keys int
+	ok   bool
+}{
+	{M{"a\x00b": 1}, keysAny, false},
+	{D{{"a", M{"b\x00": 1}}}, keysAny, false},
+	{M{"a.b": 1, "$x": 1}, keysAny, true},
+	{M{"a.b": 1}, keysStored, false},
+	{M{"$x": 1}, keysStored, false},
+	{M{"a": M{"b.c": 1}}, keysStored, false},
+	{M{"a": A{M{"$b": 1}}}, keysStored, false},
+	{M{"a": DBRef{"c", NewObjectId(), "db"}}, keysStored, true},
+	{M{"a": 1, "b": M{"c": 1}}, keysStored, true},
+	{D{{"$set", M{"a.b": 1}}, {"$inc", M{"c": 1}}}, keysUpdate, true},
+	{D{{"$set", M{"a": 1}}, {"b", 1}}, keysUpdate, false},
+	{D{{"a", 1}, {"b", M{"c": 1}}}, keysUpdate, true},
+	{D{{"a", 1}, {"$set", 1}}, keysUpdate, false},
+	{D{{"a", M{"b.c": 1}}}, keysUpdate, false},
+}
+
+func TestKeyCheck(t *testing.T) {
+	for _, tt := range keyCheckTests {
+		_, err := encodeInternal(nil, tt.doc, tt.keys)
+		if tt.ok && err != nil {
+			t.Errorf("encode(%v, %d) returned error %v", tt.doc, tt.keys, err)
+		}
+		if _, isKeyError := err.(*EncodeKeyError); !tt.ok && !isKeyError {
+			t.Errorf("encode(%v, %d) returned %v, want key error", tt.doc, tt.keys, err)
+		}
+	}
+}
+
+type textKey struct {
+	a, b string
+}
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte(k.a + ":" + k.b), nil
+}
+
+func (k *textKey) UnmarshalText(p []byte) error {
+	i := bytes.IndexByte(p, ':')
+	if i < 0 {
+		return errors.New("bad key")
+	}
+	k.a, k.b = string(p[:i]), string(p[i+1:])
+	return nil
+}
+
+// textInt is an integer key type with text marshaling.
+type textInt int
+
+func (k textInt) MarshalText() ([]byte, error) {
+	return []byte("n" + strconv.Itoa(int(k))), nil
+}
+
+func (k *textInt) UnmarshalText(p []byte) error {
+	if len(p) == 0 || p[0] != 'n' {
+		return errors.New("bad key")
+	}
+	n, err := strconv.Atoi(string(p[1:]))
+	*k = textInt(n)
+	return err
+}
+
+var mapKeyTests = []struct {
+	v interface{}
+	m M
+}{
+	{map[int64]int{-1: 1}, M{"-1": 1}},
+	{map[int8]int{2: 1}, M{"2": 1}},
+	{map[uint16]string{3: "x"}, M{"3": "x"}},
+	{map[ObjectId]int{ObjectId("\x4C\x9B\x8F\xB4\xA3\x82\xAA\xFE\x17\xC8\x6E\x63"): 1}, M{"4c9b8fb4a382aafe17c86e63": 1}},
+	{map[textKey]bool{{"x", "y"}: true}, M{"x:y": true}},
+	{map[myString]int{"_id": 1}, M{"_id": 1}},
+	{map[textInt]int{7: 1}, M{"n7": 1}},
+}
+
+type myString string
+
+func TestMapKeys(t *testing.T) {
+	for _, tt := range mapKeyTests {
+		p, err := Encode(nil, D{{"m", tt.v}})
+		if err != nil {
+			t.Errorf("Encode(%v) returned error %v", tt.v, err)
+			continue
+		}
+		expected, _ := Encode(nil, D{{"m", tt.m}})
+		if string(p) != string(expected) {
+			t.Errorf("Encode(%v) = %q, want %q", tt.v, p, expected)
+		}
+		pv := reflect.New(reflect.TypeOf(tt.v))
+		if err := Decode(p, &struct {
+			M interface{} `bson:"m"`
+		}{pv.Interface()}); err != nil {
+			t.Errorf("Decode(%q) returned error %v", p, err)
+		} else if !reflect.DeepEqual(pv.Elem().Interface(), tt.v) {
+			t.Errorf("Decode(%q) = %v, want %v", p, pv.Elem().Interface(), tt.v)
+		}
+	}
+
+	p, _ := Encode(nil, M{"m": M{"x": 1}})
+	var v struct {
+		M map[int]int `bson:"m"`
+	}
+	if e, ok := Decode(p, &v).(*DecodeConvertError); !ok || e.Path != "m.x" {
+		t.Errorf("Decode(bad key) did not return convert error at m.x")
+	}
+	if _, err := Encode(nil, map[float64]int{1: 1}); err == nil {
+		t.Errorf("Encode(map[float64]int) did not return an error")
+	}
+}
@@ -16,6 +16,8 @@ package mongo
 
 import (
 	"encoding/binary"
+	"math/bits"
+	"sync"
 )
 
 var wire = binary.LittleEndian
@@ -28,7 +30,7 @@ func (b *buffer) Next(n int) []byte {
 	begin := len(*b)
 	end := begin + n
 	if end > cap(*b) {
-		noob := make([]byte, begin, 2*cap(*b)+n)
+		noob := make([]byte, begin, bufferSize(2*cap(*b)+n))
 		copy(noob, *b)
 		*b = noob
 	}
@@ -56,3 +58,58 @@ func (b *buffer) WriteUint32(n uint32) {
 func (b *buffer) WriteUint64(n uint64) {
 	wire.PutUint64(b.Next(8), n)
 }
+
+// Buffers used for messages and documents are pooled in size classes. The
+// size of each class is a power of two from 1<<minBufferShift to
+// 1<<maxBufferShift bytes. Larger buffers are not pooled.
+const (
+	minBufferShift = 8  // 256 bytes
+	maxBufferShift = 25 // 32 MB, enough for the largest message
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// bufferClass returns the index of the smallest size class that holds n
+// bytes or -1 if n is larger than the largest size class.
+func bufferClass(n int) int {
+	if n <= 1<<minBufferShift {
+		return 0
+	}
+	i := bits.Len(uint(n-1)) - minBufferShift
+	if i >= len(bufferPools) {
+		return -1
+	}
+	return i
+}
+
+// bufferSize rounds n up to the size of a size class.
+func bufferSize(n int) int {
+	if i := bufferClass(n); i >= 0 {
+		return 1 << uint(i+minBufferShift)
+	}
+	return n
+}
+
+// getBuffer returns an empty buffer with capacity of at least n bytes.
+func getBuffer(n int) []byte {
+	i := bufferClass(n)
+	if i < 0 {
+		return make([]byte, 0, n)
+	}
+	if p, ok := bufferPools[i].Get().(*[]byte); ok {
+		return (*p)[:0]
+	}
+	return make([]byte, 0, 1<<uint(i+minBufferShift))
+}
+
+// putBuffer returns p to the pool. The caller must not use p after calling
+// this function. Buffers with a capacity that is not the size of a size
+// class are dropped.
+func putBuffer(p []byte) {
+	i := bufferClass(cap(p))
+	if i < 0 || cap(p) != 1<<uint(i+minBufferShift) {
+		return
+	}
+	p = p[:0]
+	bufferPools[i].Put(&p)
+}
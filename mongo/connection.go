@@ -49,6 +49,15 @@ type connection struct {
 	responseCount int
 	cursor        *cursor
 	br            *bufio.Reader
+
+	// Maximum size of a document sent to the server.
+	maxDocumentSize int
+
+	// Length of the last message sent to the server. The length is used to
+	// size the buffer for the next message.
+	messageLen int
+
+	serverInfo ServerInfo
 }
 
 type cursor struct {
@@ -70,10 +79,42 @@ func Dial(addr string) (Conn, error) {
 		addr = addr + ":27017"
 	}
 	c := connection{
-		addr:    addr,
-		cursors: make(map[uint32]*cursor),
+		addr:            addr,
+		cursors:         make(map[uint32]*cursor),
+		maxDocumentSize: DefaultMaxDocumentSize,
+	}
+	if err := c.connect(); err != nil {
+		return &c, err
+	}
+	if err := c.handshake(); err != nil {
+		c.Close()
+		return &c, err
+	}
+	return &c, nil
+}
+
+// handshake gets the server information using the isMaster command.
+func (c *connection) handshake() error {
+	var r struct {
+		CommandResponse
+		ServerInfo
+	}
+	if err := runInternal(c, "admin", D{{"isMaster", 1}}, runFindOptions, &r); err != nil {
+		return err
 	}
-	return &c, c.connect()
+	if err := r.Err(); err != nil {
+		return err
+	}
+	c.serverInfo = r.ServerInfo
+	if r.MaxBSONObjectSize > 0 {
+		c.maxDocumentSize = r.MaxBSONObjectSize
+	}
+	return nil
+}
+
+// ServerInfo returns information about the server.
+func (c *connection) ServerInfo() ServerInfo {
+	return c.serverInfo
 }
 
 func (c *connection) connect() error {
@@ -120,13 +161,40 @@ func (c *connection) Err() error {
 	return c.err
 }
 
-// send sets the message length and writes the message to the socket.
+// encodeDoc appends the encoding of doc to b using the given key check. The
+// server accepts commands and update documents that are slightly larger than
+// the maximum document size. Pass extra > 0 to allow for this.
+func (c *connection) encodeDoc(b buffer, doc interface{}, keys int, extra int) (buffer, error) {
+	offset := len(b)
+	b, err := encodeInternal(b, doc, keys)
+	if err != nil {
+		return b, err
+	}
+	if n := len(b) - offset; n > c.maxDocumentSize+extra {
+		return b, errors.New("mongo: document size " + strconv.Itoa(n) + " exceeds maximum of " + strconv.Itoa(c.maxDocumentSize))
+	}
+	return b, nil
+}
+
+// Extra space allowed for update documents and commands. The value is the
+// same as the server's limit.
+const maxDocumentSizeExtra = 16 * 1024
+
+// newMessage returns a buffer from the pool for writing a message.
+func (c *connection) newMessage() buffer {
+	return buffer(getBuffer(c.messageLen))
+}
+
+// send sets the message length, writes the message to the socket and returns
+// the message buffer to the pool.
 func (c *connection) send(msg []byte) error {
 	if c.err != nil {
 		return c.err
 	}
 	wire.PutUint32(msg[0:4], uint32(len(msg)))
 	_, err := c.conn.Write(msg)
+	c.messageLen = len(msg)
+	putBuffer(msg)
 	if err != nil {
 		return c.fatal(err)
 	}
@@ -147,7 +215,7 @@ func (c *connection) Update(namespace string, selector, update interface{}, opti
 		}
 	}
 
-	b := buffer(c.buf[:0])
+	b := c.newMessage()
 	b.Next(4)                    // placeholder for message length
 	b.WriteUint32(c.nextId())    // requestId
 	b.WriteUint32(0)             // responseTo
@@ -155,11 +223,11 @@ func (c *connection) Update(namespace string, selector, update interface{}, opti
 	b.WriteUint32(0)             // reserved
 	b.WriteCString(namespace)    // namespace
 	b.WriteUint32(uint32(flags)) // flags
-	b, err = Encode(b, selector)
+	b, err = c.encodeDoc(b, selector, keysAny, maxDocumentSizeExtra)
 	if err != nil {
 		return err
 	}
-	b, err = Encode(b, update)
+	b, err = c.encodeDoc(b, update, keysUpdate, maxDocumentSizeExtra)
 	if err != nil {
 		return err
 	}
@@ -176,7 +244,7 @@ func (c *connection) Insert(namespace string, options *InsertOptions, documents
 			flags |= insertContinueOnError
 		}
 	}
-	b := buffer(c.buf[:0])
+	b := c.newMessage()
 	b.Next(4)                    // placeholder for message length
 	b.WriteUint32(c.nextId())    // requestId
 	b.WriteUint32(0)             // responseTo
@@ -184,7 +252,7 @@ func (c *connection) Insert(namespace string, options *InsertOptions, documents
 	b.WriteUint32(uint32(flags)) // flags
 	b.WriteCString(namespace)    // namespace
 	for _, document := range documents {
-		b, err = Encode(b, document)
+		b, err = c.encodeDoc(b, document, keysStored, 0)
 		if err != nil {
 			return err
 		}
@@ -202,7 +270,7 @@ func (c *connection) Remove(namespace string, selector interface{}, options *Rem
 			flags |= removeSingle
 		}
 	}
-	b := buffer(c.buf[:0])
+	b := c.newMessage()
 	b.Next(4)                    // placeholder for message length
 	b.WriteUint32(c.nextId())    // requestId
 	b.WriteUint32(0)             // responseTo
@@ -210,7 +278,7 @@ func (c *connection) Remove(namespace string, selector interface{}, options *Rem
 	b.WriteUint32(0)             // reserved
 	b.WriteCString(namespace)    // namespace
 	b.WriteUint32(uint32(flags)) // flags
-	b, err = Encode(b, selector)
+	b, err = c.encodeDoc(b, selector, keysAny, maxDocumentSizeExtra)
 	if err != nil {
 		return err
 	}
@@ -261,7 +329,7 @@ func (c *connection) Find(namespace string, query interface{}, options *FindOpti
 		}
 	}
 
-	b := buffer(c.buf[:0])
+	b := c.newMessage()
 	b.Next(4)                         // placeholder for message length
 	b.WriteUint32(r.requestId)        // requestId
 	b.WriteUint32(0)                  // responseTo
@@ -270,12 +338,12 @@ func (c *connection) Find(namespace string, query interface{}, options *FindOpti
 	b.WriteCString(namespace)         // namespace
 	b.WriteUint32(uint32(skip))       // numberToSkip
 	b.WriteUint32(r.numberToReturn()) // numberToReturn
-	b, err := Encode(b, query)
+	b, err := c.encodeDoc(b, query, keysAny, maxDocumentSizeExtra)
 	if err != nil {
 		return nil, err
 	}
 	if fields != nil {
-		b, err = Encode(b, fields)
+		b, err = c.encodeDoc(b, fields, keysAny, maxDocumentSizeExtra)
 		if err != nil {
 			return nil, err
 		}
@@ -291,7 +359,7 @@ func (c *connection) Find(namespace string, query interface{}, options *FindOpti
 
 func (c *connection) getMore(r *cursor) error {
 	requestId := c.nextId()
-	b := buffer(c.buf[:0])
+	b := c.newMessage()
 	b.Next(4)                   // placeholder for message length
 	b.WriteUint32(requestId)    // requestId
 	b.WriteUint32(0)            // responseTo
@@ -309,7 +377,7 @@ func (c *connection) getMore(r *cursor) error {
 }
 
 func (c *connection) killCursors(cursorIds ...uint64) error {
-	b := buffer(c.buf[:0])
+	b := c.newMessage()
 	b.Next(4)                             // placeholder for message length
 	b.WriteUint32(c.nextId())             // requestId
 	b.WriteUint32(0)                      // responseTo
@@ -322,8 +390,10 @@ func (c *connection) killCursors(cursorIds ...uint64) error {
 	return c.send(b)
 }
 
-// readDoc reads a single document from the connection.
-func (c *connection) readDoc(alloc bool) ([]byte, error) {
+// readDoc reads a single document from the connection. The document is read
+// to a buffer from the pool. Return the buffer to the pool with putBuffer
+// when done with the document.
+func (c *connection) readDoc() ([]byte, error) {
 	if c.responseLen < 4 {
 		return nil, c.fatal(errors.New("mongo: incomplete document in message"))
 	}
@@ -331,16 +401,14 @@ func (c *connection) readDoc(alloc bool) ([]byte, error) {
 	if err != nil {
 		return nil, c.fatal(err)
 	}
-	n := int(wire.Uint32(b))
+	n, err := docSize(b, c.maxDocumentSize+maxDocumentSizeExtra)
+	if err != nil {
+		return nil, c.fatal(err)
+	}
 	if c.responseLen < n {
 		return nil, c.fatal(errors.New("mongo: incomplete document in message"))
 	}
-	var p []byte
-	if n > len(c.buf) || alloc {
-		p = make([]byte, n)
-	} else {
-		p = c.buf[:n]
-	}
+	p := getBuffer(n)[:n]
 	_, err = io.ReadFull(c.br, p)
 	if err != nil {
 		return nil, c.fatal(err)
@@ -387,7 +455,7 @@ func (c *connection) receive() error {
 	// Slurp up documents for current cursor.
 	for c.responseCount > 0 {
 		r := c.cursor
-		p, err := c.readDoc(true)
+		p, err := c.readDoc()
 		if err != nil {
 			return err
 		}
@@ -435,7 +503,7 @@ func (c *connection) receive() error {
 	}
 
 	if flags&cursorNotFound != 0 {
-		r.fatal(errors.New("mongo: cursor not found"))
+		r.fatal(ErrCursorNotFound)
 		if c.responseCount != 0 || c.responseLen != 0 {
 			return c.fatal(errors.New("mongo: unexpected data after cursor not found."))
 		}
@@ -445,12 +513,13 @@ func (c *connection) receive() error {
 		if c.responseCount != 1 {
 			return c.fatal(errors.New("mongo: unexpected number of docs for query failure."))
 		}
-		p, err := c.readDoc(false)
+		p, err := c.readDoc()
 		if err != nil {
 			return err
 		}
 		var m M
 		err = Decode(p, &m)
+		putBuffer(p)
 		if err != nil {
 			r.fatal(err)
 		} else if s, ok := m["$err"].(string); ok {
@@ -514,6 +583,10 @@ func (r *cursor) Close() error {
 	if r.requestId != 0 && r.conn.cursors != nil {
 		delete(r.conn.cursors, r.requestId)
 	}
+	for _, p := range r.docs {
+		putBuffer(p)
+	}
+	r.docs = nil
 	r.err = errors.New("mongo: cursor closed")
 	r.conn = nil
 	return nil
@@ -577,6 +650,36 @@ func (r *cursor) HasNext() bool {
 	return false
 }
 
+func (r *cursor) ID() int64 {
+	return int64(r.cursorId)
+}
+
+func (r *cursor) RemainingInBatch() int {
+	if r.err != nil {
+		return 0
+	}
+	n := len(r.docs)
+	if r.conn.cursor == r {
+		n += r.conn.responseCount
+	}
+	return n
+}
+
+func (r *cursor) NextBatch() ([]BSONData, error) {
+	if r.err != nil && r.err != Done {
+		return nil, r.err
+	}
+	var batch []BSONData
+	for r.RemainingInBatch() > 0 {
+		var bd BSONData
+		if err := r.Next(&bd); err != nil {
+			return batch, err
+		}
+		batch = append(batch, bd)
+	}
+	return batch, nil
+}
+
 func (r *cursor) Next(value interface{}) error {
 	if !r.HasNext() {
 		return Done
@@ -594,7 +697,7 @@ func (r *cursor) Next(value interface{}) error {
 		r.docs = r.docs[1:]
 	case r.conn.cursor == r:
 		var err error
-		p, err = r.conn.readDoc(false)
+		p, err = r.conn.readDoc()
 		if err != nil {
 			return r.fatal(err)
 		}
@@ -603,6 +706,7 @@ func (r *cursor) Next(value interface{}) error {
 	}
 
 	err := Decode(p, value)
+	putBuffer(p)
 
 	r.count += 1
 	if r.limit > 0 && r.count >= r.limit {
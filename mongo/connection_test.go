@@ -14,7 +14,16 @@
 
 package mongo
 
-import "testing"
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
 
 func dialAndDrop(t *testing.T, dbname, collectionName string) Collection {
 	c, err := Dial("127.0.0.1")
@@ -254,3 +263,259 @@ func TestCursorCloseAfterConnectionClose(t *testing.T) {
 	r.Close()
 	r.Next(&m)
 }
+
+func TestInsertChecks(t *testing.T) {
+	errNotConnected := errors.New("not connected")
+	c := &connection{cursors: make(map[uint32]*cursor), maxDocumentSize: 100, err: errNotConnected}
+	if err := c.Insert("db.c", nil, M{"a.b": 1}); err == nil || err == errNotConnected {
+		t.Errorf("Insert with invalid key returned %v, want key error", err)
+	}
+	if err := c.Insert("db.c", nil, M{"a": strings.Repeat("x", 100)}); err == nil || err == errNotConnected {
+		t.Errorf("Insert with large document returned %v, want size error", err)
+	}
+	if err := c.Insert("db.c", nil, M{"a": "x"}); err != errNotConnected {
+		t.Errorf("Insert returned %v, want %v", err, errNotConnected)
+	}
+	if err := c.Update("db.c", M{"a.b": 1}, M{"$set": M{"a.b": 2}}, nil); err != errNotConnected {
+		t.Errorf("Update returned %v, want %v", err, errNotConnected)
+	}
+	if err := c.Update("db.c", nil, M{"a.b": 2}, nil); err == nil || err == errNotConnected {
+		t.Errorf("Update with invalid replacement key returned %v, want key error", err)
+	}
+}
+
+// replyConn is a net.Conn that discards written messages and replies to
+// each query and get more message with the documents in docs. If handler is
+// set, then queries are replied to with the document returned from handler.
+type replyConn struct {
+	net.Conn
+	docs    [][]byte
+	handler func(query M) interface{}
+	r       bytes.Buffer
+}
+
+func (c *replyConn) Write(p []byte) (int, error) {
+	opCode := wire.Uint32(p[12:16])
+	if opCode != 2004 && opCode != 2005 {
+		return len(p), nil
+	}
+	docs := c.docs
+	if c.handler != nil && opCode == 2004 {
+		// Skip header, flags, namespace, skip and limit to get the query.
+		i := 20 + bytes.IndexByte(p[20:], 0) + 1 + 8
+		var query M
+		if err := Decode(p[i:], &query); err != nil {
+			return 0, err
+		}
+		doc, err := Encode(nil, c.handler(query))
+		if err != nil {
+			return 0, err
+		}
+		docs = [][]byte{doc}
+	}
+	n := 36
+	for _, doc := range docs {
+		n += len(doc)
+	}
+	var h [36]byte
+	wire.PutUint32(h[0:4], uint32(n))            // messageLength
+	wire.PutUint32(h[8:12], wire.Uint32(p[4:8])) // responseTo
+	wire.PutUint32(h[12:16], 1)                  // opCode
+	wire.PutUint32(h[32:36], uint32(len(docs)))  // numberReturned
+	c.r.Write(h[:])
+	for _, doc := range docs {
+		c.r.Write(doc)
+	}
+	return len(p), nil
+}
+
+func (c *replyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *replyConn) Close() error {
+	return nil
+}
+
+func newReplyConnection(docs [][]byte) *connection {
+	rc := &replyConn{docs: docs}
+	return &connection{
+		conn:            rc,
+		br:              bufio.NewReader(rc),
+		cursors:         make(map[uint32]*cursor),
+		maxDocumentSize: DefaultMaxDocumentSize,
+	}
+}
+
+func TestCursorBuffers(t *testing.T) {
+	var docs [][]byte
+	for i := 0; i < 100; i++ {
+		doc, err := Encode(nil, D{{"x", i}, {"s", strings.Repeat("x", i*50)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	c := newReplyConnection(docs)
+
+	// Run two cursors concurrently so that documents are buffered by the
+	// first cursor when reading the second cursor's response.
+	var r [2]Cursor
+	for i := range r {
+		var err error
+		r[i], err = c.Find("db.c", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < len(docs); i++ {
+		for j := len(r) - 1; j >= 0; j-- {
+			var m M
+			if err := r[j].Next(&m); err != nil {
+				t.Fatalf("r[%d].Next() returned %v", j, err)
+			}
+			if m["x"] != i || m["s"] != strings.Repeat("x", i*50) {
+				t.Fatalf("r[%d] doc %d = %v", j, i, m)
+			}
+		}
+	}
+	for i := range r {
+		r[i].Close()
+	}
+}
+
+func BenchmarkInsertBulk(b *testing.B) {
+	c := newReplyConnection(nil)
+	docs := make([]interface{}, 1000)
+	for i := range docs {
+		o := newBenchOrder()
+		o.Id = NewObjectId()
+		docs[i] = o
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := c.Insert("db.c", nil, docs...); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(c.messageLen))
+}
+
+func BenchmarkCursorRead(b *testing.B) {
+	wide := make(M)
+	for i := 0; i < 100; i++ {
+		wide["field"+strconv.Itoa(i)] = strings.Repeat("x", 20)
+	}
+	wide["x"] = 1
+	doc, err := Encode(nil, wide)
+	if err != nil {
+		b.Fatal(err)
+	}
+	docs := make([][]byte, 100)
+	for i := range docs {
+		docs[i] = doc
+	}
+	c := newReplyConnection(docs)
+	var v struct{ X int }
+	b.ReportAllocs()
+	b.SetBytes(int64(len(doc) * len(docs)))
+	for i := 0; i < b.N; i++ {
+		r, err := c.Find("db.c", nil, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for r.HasNext() {
+			if err := r.Next(&v); err != nil {
+				b.Fatal(err)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestCursorNextBatch(t *testing.T) {
+	var docs [][]byte
+	for i := 0; i < 5; i++ {
+		doc, _ := Encode(nil, D{{"x", i}})
+		docs = append(docs, doc)
+	}
+	c := newReplyConnection(docs)
+	cursor, err := c.Find("db.c", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	r := cursor.(BatchCursor)
+	var m M
+	if err := r.Next(&m); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.RemainingInBatch(); n != 4 {
+		t.Errorf("RemainingInBatch() = %d, want 4", n)
+	}
+	batch, err := r.NextBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 4 {
+		t.Fatalf("len(batch) = %d, want 4", len(batch))
+	}
+	for i, bd := range batch {
+		if err := bd.Decode(&m); err != nil || m["x"] != i+1 {
+			t.Errorf("batch[%d] = %v, %v, want x: %d", i, m, err, i+1)
+		}
+	}
+	if id := r.ID(); id != 0 {
+		t.Errorf("ID() = %d, want 0", id)
+	}
+	if batch, err := r.NextBatch(); len(batch) != 0 || err != nil {
+		t.Errorf("NextBatch() at end returned %d documents, %v; want 0, nil", len(batch), err)
+	}
+	if r.HasNext() {
+		t.Error("HasNext() at end returned true")
+	}
+}
+
+func TestDialHandshakeError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The server replies to the isMaster command with an error and then
+	// waits for the client to close the connection.
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+		rc := &replyConn{handler: func(query M) interface{} {
+			return D{{"ok", 0}, {"errmsg", "not authorized"}}
+		}}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			closed <- err
+			return
+		}
+		rc.Write(buf[:n])
+		conn.Write(rc.r.Bytes())
+		_, err = conn.Read(buf)
+		closed <- err
+	}()
+
+	c, err := Dial(l.Addr().String())
+	if err == nil {
+		t.Fatal("Dial() did not return handshake error")
+	}
+	if c.Err() == nil {
+		t.Error("connection not closed after handshake error")
+	}
+	if err := <-closed; err != io.EOF {
+		t.Errorf("server read returned %v, want io.EOF", err)
+	}
+}
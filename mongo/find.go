@@ -0,0 +1,293 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+)
+
+// useFindCommand returns true if the query should be executed with the find
+// command instead of an OP_QUERY message. Exhaust cursors are not supported
+// by the find command.
+func (q *Query) useFindCommand() bool {
+	return serverInfo(q.Conn).MaxWireVersion >= wireVersionFindCommand && !q.Options.Exhaust
+}
+
+// checkLegacy returns an error if the query uses options that require the
+// find command.
+func (q *Query) checkLegacy() error {
+	if q.Spec.ReadConcern != nil ||
+		q.Spec.Collation != nil ||
+		q.Spec.AllowDiskUse ||
+		q.Spec.Let != nil {
+		return errors.New("mongo: query option requires server support for the find command")
+	}
+	return nil
+}
+
+// findCommand returns the find command for the query.
+//
+// More information: http://docs.mongodb.org/manual/reference/command/find/
+func (q *Query) findCommand(options *FindOptions) D {
+	_, cname := SplitNamespace(q.Namespace)
+	cmd := D{{"find", cname}}
+	if q.Spec.Query != nil {
+		cmd.Append("filter", q.Spec.Query)
+	}
+	if q.Spec.Sort != nil {
+		cmd.Append("sort", q.Spec.Sort)
+	}
+	if options.Fields != nil {
+		cmd.Append("projection", options.Fields)
+	}
+	if q.Spec.Hint != nil {
+		cmd.Append("hint", q.Spec.Hint)
+	}
+	if options.Skip > 0 {
+		cmd.Append("skip", options.Skip)
+	}
+
+	// A negative limit or batch size requests a single batch as in OP_QUERY.
+	limit, batchSize, singleBatch := options.Limit, options.BatchSize, false
+	if limit < 0 {
+		limit, singleBatch = -limit, true
+	}
+	if batchSize < 0 {
+		batchSize, singleBatch = -batchSize, true
+	}
+	if limit > 0 {
+		cmd.Append("limit", limit)
+	}
+	if batchSize > 0 {
+		cmd.Append("batchSize", batchSize)
+	}
+	if singleBatch {
+		cmd.Append("singleBatch", true)
+	}
+
+	if q.Spec.Comment != nil {
+		cmd.Append("comment", q.Spec.Comment)
+	}
+	if q.Spec.MaxTimeMS > 0 {
+		cmd.Append("maxTimeMS", q.Spec.MaxTimeMS)
+	}
+	if q.Spec.ReadConcern != nil {
+		cmd.Append("readConcern", q.Spec.ReadConcern)
+	}
+	if q.Spec.Max != nil {
+		cmd.Append("max", q.Spec.Max)
+	}
+	if q.Spec.Min != nil {
+		cmd.Append("min", q.Spec.Min)
+	}
+	if q.Spec.ReturnKey {
+		cmd.Append("returnKey", true)
+	}
+	if q.Spec.ShowRecordId {
+		cmd.Append("showRecordId", true)
+	}
+	if q.Spec.Snapshot {
+		cmd.Append("snapshot", true)
+	}
+	if options.Tailable {
+		cmd.Append("tailable", true)
+	}
+	if options.NoCursorTimeout {
+		cmd.Append("noCursorTimeout", true)
+	}
+	if options.AwaitData {
+		cmd.Append("awaitData", true)
+	}
+	if options.PartialResults {
+		cmd.Append("allowPartialResults", true)
+	}
+	if q.Spec.Collation != nil {
+		cmd.Append("collation", q.Spec.Collation)
+	}
+	if q.Spec.AllowDiskUse {
+		cmd.Append("allowDiskUse", true)
+	}
+	if q.Spec.Let != nil {
+		cmd.Append("let", q.Spec.Let)
+	}
+	return cmd
+}
+
+// codeCursorNotFound is the server's error code for a getMore command on a
+// cursor that does not exist.
+const codeCursorNotFound = 43
+
+// cursorResponse is the response to the find and getMore commands.
+type cursorResponse struct {
+	CommandResponse
+	Code   int `bson:"code"`
+	Cursor struct {
+		Id         int64      `bson:"id"`
+		Namespace  string     `bson:"ns"`
+		FirstBatch []BSONData `bson:"firstBatch"`
+		NextBatch  []BSONData `bson:"nextBatch"`
+	} `bson:"cursor"`
+}
+
+// commandCursor is a cursor over the results of a find command. The cursor
+// fetches more results with the getMore command.
+type commandCursor struct {
+	conn       Conn
+	dbname     string
+	collection string
+	options    *FindOptions
+	cursorId   int64
+	batchSize  int
+	tailable   bool
+	maxAwait   int64
+	docs       []BSONData
+	err        error
+}
+
+// findCursor executes the query with the find command.
+func (q *Query) findCursor(options *FindOptions) (Cursor, error) {
+	dbname, cname := SplitNamespace(q.Namespace)
+	var r cursorResponse
+	if err := runInternal(q.Conn, dbname, q.findCommand(options), commandOptions(options), &r); err != nil {
+		return nil, err
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	batchSize := options.BatchSize
+	if batchSize < 0 {
+		batchSize = 0
+	}
+	c := &commandCursor{
+		conn:       q.Conn,
+		dbname:     dbname,
+		collection: cname,
+		options:    commandOptions(options),
+		cursorId:   r.Cursor.Id,
+		batchSize:  batchSize,
+		tailable:   options.Tailable,
+		maxAwait:   q.Spec.MaxAwaitTimeMS,
+		docs:       r.Cursor.FirstBatch,
+	}
+	return c, nil
+}
+
+func (c *commandCursor) getMore() error {
+	cmd := D{{"getMore", c.cursorId}, {"collection", c.collection}}
+	if c.batchSize > 0 {
+		cmd.Append("batchSize", c.batchSize)
+	}
+	if c.tailable && c.maxAwait > 0 {
+		cmd.Append("maxTimeMS", c.maxAwait)
+	}
+	var r cursorResponse
+	if err := runInternal(c.conn, c.dbname, cmd, c.options, &r); err != nil {
+		return err
+	}
+	if r.Code == codeCursorNotFound {
+		return ErrCursorNotFound
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	c.cursorId = r.Cursor.Id
+	c.docs = r.Cursor.NextBatch
+	return nil
+}
+
+func (c *commandCursor) Close() error {
+	if c.err != nil {
+		return nil
+	}
+	if c.cursorId != 0 {
+		var r CommandResponse
+		runInternal(c.conn, c.dbname,
+			D{{"killCursors", c.collection}, {"cursors", A{c.cursorId}}},
+			c.options, &r)
+		c.cursorId = 0
+	}
+	c.docs = nil
+	c.err = errors.New("mongo: cursor closed")
+	c.conn = nil
+	return nil
+}
+
+func (c *commandCursor) fatal(err error) error {
+	if c.err == nil {
+		c.Close()
+		c.err = err
+	}
+	return err
+}
+
+func (c *commandCursor) Err() error {
+	return c.err
+}
+
+func (c *commandCursor) HasNext() bool {
+	// As with the OP_QUERY cursor, HasNext returns true on an error other
+	// than Done so that the error is returned by a subsequent call to Next.
+	for {
+		switch {
+		case c.err != nil:
+			return c.err != Done
+		case len(c.docs) > 0:
+			return true
+		case c.cursorId == 0:
+			c.fatal(Done)
+			return false
+		}
+		if err := c.getMore(); err != nil {
+			c.fatal(err)
+			return true
+		}
+		if len(c.docs) == 0 && c.tailable {
+			return false
+		}
+	}
+}
+
+func (c *commandCursor) Next(value interface{}) error {
+	if !c.HasNext() {
+		return Done
+	}
+	if c.err != nil {
+		return c.err
+	}
+	bd := c.docs[0]
+	c.docs[0] = BSONData{}
+	c.docs = c.docs[1:]
+	return bd.Decode(value)
+}
+
+func (c *commandCursor) ID() int64 {
+	return c.cursorId
+}
+
+func (c *commandCursor) RemainingInBatch() int {
+	if c.err != nil {
+		return 0
+	}
+	return len(c.docs)
+}
+
+func (c *commandCursor) NextBatch() ([]BSONData, error) {
+	if c.err != nil && c.err != Done {
+		return nil, c.err
+	}
+	batch := c.docs
+	c.docs = nil
+	return batch, nil
+}
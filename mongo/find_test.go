@@ -0,0 +1,215 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var findCommandTests = []struct {
+	q   Query
+	cmd D
+}{
+	{
+		Query{Namespace: "db.c"},
+		D{{"find", "c"}},
+	},
+	{
+		Query{Namespace: "db.c", Spec: QuerySpec{Query: D{{"x", 1}}, Sort: D{{"y", -1}}, Hint: "y_-1"}},
+		D{{"find", "c"}, {"filter", D{{"x", 1}}}, {"sort", D{{"y", -1}}}, {"hint", "y_-1"}},
+	},
+	{
+		Query{Namespace: "db.c", Options: FindOptions{Fields: D{{"x", 1}}, Skip: 2, Limit: 3, BatchSize: 4}},
+		D{{"find", "c"}, {"projection", D{{"x", 1}}}, {"skip", 2}, {"limit", 3}, {"batchSize", 4}},
+	},
+	{
+		Query{Namespace: "db.c", Options: FindOptions{Limit: -3}},
+		D{{"find", "c"}, {"limit", 3}, {"singleBatch", true}},
+	},
+	{
+		Query{Namespace: "db.c", Options: FindOptions{Limit: 1, BatchSize: -1}},
+		D{{"find", "c"}, {"limit", 1}, {"batchSize", 1}, {"singleBatch", true}},
+	},
+	{
+		Query{Namespace: "db.c", Spec: QuerySpec{
+			Comment:      "hello",
+			MaxTimeMS:    100,
+			ReadConcern:  &ReadConcern{Level: "majority"},
+			ReturnKey:    true,
+			ShowRecordId: true,
+			Collation:    &Collation{Locale: "fr", Strength: 2},
+			AllowDiskUse: true,
+			Let:          D{{"v", 1}},
+		}},
+		D{{"find", "c"},
+			{"comment", "hello"},
+			{"maxTimeMS", int64(100)},
+			{"readConcern", D{{"level", "majority"}}},
+			{"returnKey", true},
+			{"showRecordId", true},
+			{"collation", D{{"locale", "fr"}, {"strength", 2}}},
+			{"allowDiskUse", true},
+			{"let", D{{"v", 1}}}},
+	},
+	{
+		Query{Namespace: "db.c", Options: FindOptions{Tailable: true, AwaitData: true, NoCursorTimeout: true, PartialResults: true}},
+		D{{"find", "c"}, {"tailable", true}, {"noCursorTimeout", true}, {"awaitData", true}, {"allowPartialResults", true}},
+	},
+}
+
+func TestFindCommand(t *testing.T) {
+	for _, tt := range findCommandTests {
+		actual, err := Encode(nil, tt.q.findCommand(&tt.q.Options))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := Encode(nil, tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			var m M
+			Decode(actual, &m)
+			t.Errorf("findCommand(%+v) = %v, want %v", tt.q, m, tt.cmd)
+		}
+	}
+}
+
+// newFindConnection returns a connection to a fake server that supports the
+// find command. The server returns docs in batches of batchSize documents.
+// The commands received by the server are appended to *commands.
+func newFindConnection(docs []interface{}, batchSize int, commands *[]string) *connection {
+	c := newReplyConnection(nil)
+	c.serverInfo.MaxWireVersion = 6
+	pos := 0
+	batch := func() (A, int64) {
+		end := pos + batchSize
+		if end >= len(docs) {
+			end = len(docs)
+		}
+		b := A(docs[pos:end])
+		pos = end
+		if pos == len(docs) {
+			return b, 0
+		}
+		return b, 42
+	}
+	c.conn.(*replyConn).handler = func(query M) interface{} {
+		switch {
+		case query["find"] != nil:
+			*commands = append(*commands, "find")
+			b, id := batch()
+			return D{{"ok", 1}, {"cursor", D{{"id", id}, {"ns", "db.c"}, {"firstBatch", b}}}}
+		case query["getMore"] != nil:
+			*commands = append(*commands, "getMore")
+			b, id := batch()
+			return D{{"ok", 1}, {"cursor", D{{"id", id}, {"ns", "db.c"}, {"nextBatch", b}}}}
+		case query["killCursors"] != nil:
+			*commands = append(*commands, "killCursors")
+			return D{{"ok", 1}}
+		}
+		return D{{"ok", 0}, {"errmsg", "unknown command"}}
+	}
+	return c
+}
+
+func TestFindCursor(t *testing.T) {
+	var docs []interface{}
+	for i := 0; i < 5; i++ {
+		docs = append(docs, D{{"x", i}})
+	}
+
+	var commands []string
+	c := newFindConnection(docs, 2, &commands)
+	var result []struct {
+		X int `bson:"x"`
+	}
+	if err := (&Query{Conn: c, Namespace: "db.c"}).All(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(docs) {
+		t.Fatalf("len(result) = %d, want %d", len(result), len(docs))
+	}
+	for i, r := range result {
+		if r.X != i {
+			t.Errorf("result[%d].X = %d, want %d", i, r.X, i)
+		}
+	}
+	if expected := []string{"find", "getMore", "getMore"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("commands = %v, want %v", commands, expected)
+	}
+
+	commands = nil
+	c = newFindConnection(docs, 2, &commands)
+	var m M
+	if err := (&Query{Conn: c, Namespace: "db.c"}).One(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m["x"] != 0 {
+		t.Errorf("One() returned %v, want x: 0", m)
+	}
+	if expected := []string{"find", "killCursors"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("commands = %v, want %v", commands, expected)
+	}
+}
+
+func TestFindLegacy(t *testing.T) {
+	c := newReplyConnection(nil)
+	q := &Query{Conn: c, Namespace: "db.c"}
+	q.Spec.Collation = &Collation{Locale: "fr"}
+	if _, err := q.Cursor(); err == nil {
+		t.Error("Cursor() with collation on legacy server did not return error")
+	}
+}
+
+func TestFindCursorNextBatch(t *testing.T) {
+	var docs []interface{}
+	for i := 0; i < 5; i++ {
+		docs = append(docs, D{{"x", i}})
+	}
+	var commands []string
+	c := newFindConnection(docs, 2, &commands)
+	cursor, err := (&Query{Conn: c, Namespace: "db.c"}).BatchSize(2).Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	r := cursor.(BatchCursor)
+	if id := r.ID(); id != 42 {
+		t.Errorf("ID() = %d, want 42", id)
+	}
+	if n := r.RemainingInBatch(); n != 2 {
+		t.Errorf("RemainingInBatch() = %d, want 2", n)
+	}
+	var sizes []int
+	for r.HasNext() {
+		batch, err := r.NextBatch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(batch))
+		if expected := []string{"find", "getMore", "getMore"}[:len(sizes)]; !reflect.DeepEqual(commands, expected) {
+			t.Errorf("commands after batch %d = %v, want %v", len(sizes), commands, expected)
+		}
+	}
+	if expected := []int{2, 2, 1}; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("batch sizes = %v, want %v", sizes, expected)
+	}
+	if id := r.ID(); id != 0 {
+		t.Errorf("ID() = %d, want 0", id)
+	}
+}
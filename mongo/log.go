@@ -41,6 +41,10 @@ func (c *loggingConn) Close() error {
 	return err
 }
 
+func (c *loggingConn) ServerInfo() ServerInfo {
+	return serverInfo(c.Conn)
+}
+
 func (c *loggingConn) Update(namespace string, selector, update interface{}, options *UpdateOptions) error {
 	err := c.Conn.Update(namespace, selector, update, options)
 	var buf bytes.Buffer
@@ -86,7 +90,12 @@ func (c *loggingConn) Find(namespace string, query interface{}, options *FindOpt
 	if r != nil {
 		c.cursorId += 1
 		prefix = fmt.Sprintf("%s%d.", c.prefix, c.cursorId)
-		r = &logCursor{r, c.log, prefix}
+		lc := &logCursor{r, c.log, prefix}
+		if bc, ok := r.(BatchCursor); ok {
+			r = &logBatchCursor{lc, bc}
+		} else {
+			r = lc
+		}
 	}
 	var buf bytes.Buffer
 	if options != nil {
@@ -149,3 +158,27 @@ func (r *logCursor) Next(value interface{}) error {
 	r.log.Printf("%sNext() (%v, %v)", r.prefix, m, err)
 	return err
 }
+
+// logBatchCursor is a logCursor for a cursor that implements BatchCursor.
+type logBatchCursor struct {
+	*logCursor
+	bc BatchCursor
+}
+
+func (r *logBatchCursor) RemainingInBatch() int {
+	return r.bc.RemainingInBatch()
+}
+
+func (r *logBatchCursor) ID() int64 {
+	return r.bc.ID()
+}
+
+func (r *logBatchCursor) NextBatch() ([]BSONData, error) {
+	batch, err := r.bc.NextBatch()
+	m := make([]M, len(batch))
+	for i, bd := range batch {
+		bd.Decode(&m[i])
+	}
+	r.log.Printf("%sNextBatch() (%v, %v)", r.prefix, m, err)
+	return batch, err
+}
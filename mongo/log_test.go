@@ -0,0 +1,54 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogCursorNextBatch(t *testing.T) {
+	var docs [][]byte
+	for i := 1; i <= 2; i++ {
+		doc, err := Encode(nil, D{{"x", i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	c := newReplyConnection(docs)
+	var buf bytes.Buffer
+	conn := NewLoggingConn(c, log.New(&buf, "", 0), "")
+	r, err := conn.Find("db.c", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !r.HasNext() {
+		t.Fatal("HasNext() returned false")
+	}
+	batch, err := r.(BatchCursor).NextBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("len(batch) = %d, want 2", len(batch))
+	}
+	if expected := "1.NextBatch() ([map[x:1] map[x:2]], <nil>)\n"; !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("log = %q, want suffix %q", buf.String(), expected)
+	}
+}
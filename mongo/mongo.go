@@ -30,6 +30,9 @@ import "errors"
 // Cursor has no more results.
 var Done = errors.New("mongo: cursor has no more results")
 
+// The server does not have the cursor. The cursor timed out or was killed.
+var ErrCursorNotFound = errors.New("mongo: cursor not found")
+
 // InsertOptions specifies options for the Conn.Insert method.
 type InsertOptions struct {
 	// If true, the server will not stop processing a bulk insert if one insert fails.
@@ -95,6 +98,44 @@ type FindOptions struct {
 	BatchSize int
 }
 
+// ServerInfo describes the server for a connection. The fields are set from
+// the response to the isMaster command when the connection is established.
+//
+// Connections returned by Dial and Pool.Get have a ServerInfo() ServerInfo
+// method. Applications that wrap connections should also implement this
+// method to enable features that depend on the server version.
+//
+// More information: http://docs.mongodb.org/manual/reference/command/isMaster/
+type ServerInfo struct {
+	// Wire protocol versions supported by the server.
+	MinWireVersion int `bson:"minWireVersion"`
+	MaxWireVersion int `bson:"maxWireVersion"`
+
+	// Maximum size of a document.
+	MaxBSONObjectSize int `bson:"maxBsonObjectSize"`
+
+	// Maximum size of a message.
+	MaxMessageSizeBytes int `bson:"maxMessageSizeBytes"`
+
+	// Maximum number of documents in a write command.
+	MaxWriteBatchSize int `bson:"maxWriteBatchSize"`
+}
+
+// Wire protocol version for servers that support the find and getMore
+// commands.
+const wireVersionFindCommand = 4
+
+// serverInfo returns the server information for conn or the zero value if
+// conn does not provide the information.
+func serverInfo(conn Conn) ServerInfo {
+	if c, ok := conn.(interface {
+		ServerInfo() ServerInfo
+	}); ok {
+		return c.ServerInfo()
+	}
+	return ServerInfo{}
+}
+
 // A Conn represents a connection to a MongoDB server.
 //
 // When the application is done using the connection, the application must call
@@ -112,10 +153,14 @@ type Conn interface {
 	// Error returns non-nil if the connection has a permanent error.
 	Err() error
 
-	// Update document specified by selector with update.
+	// Update document specified by selector with update. The update is a
+	// document of update operators or a replacement document. The keys in
+	// a replacement document are checked as described for Insert.
 	Update(namespace string, selector, update interface{}, options *UpdateOptions) error
 
-	// Insert documents.
+	// Insert documents. Insert returns an *EncodeKeyError if a key in a
+	// document contains '.' or starts with '$'. The keys $ref, $id and $db
+	// used by DBRef are allowed.
 	Insert(namespace string, options *InsertOptions, documents ...interface{}) error
 
 	// Remove documents specified by selector.
@@ -165,3 +210,34 @@ type Cursor interface {
 	// a non-nil pointer to struct or map.
 	Next(value interface{}) error
 }
+
+// BatchCursor is a cursor with access to the current batch of documents. The
+// cursors returned by the connections in this package implement BatchCursor.
+// Use a type assertion to check for the interface:
+//
+//  if bc, ok := cursor.(mongo.BatchCursor); ok {
+//      for bc.HasNext() {
+//          batch, err := bc.NextBatch()
+//          if err != nil {
+//              return err
+//          }
+//          // Do something with the documents in batch.
+//      }
+//  }
+type BatchCursor interface {
+	Cursor
+
+	// NextBatch returns the documents remaining in the current batch. The
+	// function does not fetch the next batch from the server. If no
+	// documents remain in the batch, then NextBatch returns an empty slice.
+	// Call HasNext to fetch the next batch.
+	NextBatch() ([]BSONData, error)
+
+	// RemainingInBatch returns the number of documents that can be returned
+	// without a round trip to the server.
+	RemainingInBatch() int
+
+	// ID returns the server's identifier for the cursor or zero if the
+	// cursor is exhausted on the server.
+	ID() int64
+}
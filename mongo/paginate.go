@@ -0,0 +1,282 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Paginator returns pages of query results using range filters on the sort
+// keys instead of skipping documents. The position in the result set is
+// represented by an opaque token containing the sort key values of a
+// document. Tokens are safe to use in URLs.
+//
+//  p := c.Find(filter).Paginate(D{{"created", -1}}, 20)
+//  var page []Post
+//  tokens, err := p.Next(r.FormValue("after"), &page)
+//
+// Pagination depends on a total order of the documents. The _id field is
+// added to the sort specification as a tie-breaker if not already present.
+type Paginator struct {
+	query    Query
+	sort     D
+	pageSize int
+}
+
+// PageTokens contains the tokens for the pages adjacent to a page returned by
+// a Paginator. A token is empty if there is no adjacent page.
+type PageTokens struct {
+	// Token for the page before this page. Pass to Paginator.Prev.
+	Prev string
+
+	// Token for the page after this page. Pass to Paginator.Next.
+	Next string
+}
+
+// Paginate returns a paginator for the query. The sort order is specified by
+// (key, direction) pairs where direction is 1 for ascending order and -1 for
+// descending order. The query's skip and limit options are ignored. Subsequent
+// changes to the query object are ignored by the paginator.
+func (q *Query) Paginate(sort D, pageSize int) *Paginator {
+	p := &Paginator{query: *q, pageSize: pageSize}
+	hasId := false
+	for _, item := range sort {
+		p.sort.Append(item.Key, sortDirection(item.Value))
+		hasId = hasId || item.Key == "_id"
+	}
+	if !hasId {
+		p.sort.Append("_id", 1)
+	}
+	return p
+}
+
+// sortDirection returns the direction of a sort specification value.
+func sortDirection(v interface{}) int {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return -1
+		}
+	case int32:
+		if v < 0 {
+			return -1
+		}
+	case int64:
+		if v < 0 {
+			return -1
+		}
+	case float64:
+		if v < 0 {
+			return -1
+		}
+	}
+	return 1
+}
+
+// Next returns the page following the document represented by token in
+// *slicep. The first page is returned if token is empty. The slicep argument
+// must be a pointer to a slice and the elements of the slice must be valid
+// document types.
+func (p *Paginator) Next(token string, slicep interface{}) (PageTokens, error) {
+	return p.page(token, false, slicep)
+}
+
+// Prev returns the page preceding the document represented by token in
+// *slicep. The last page is returned if token is empty. The documents in the
+// page are in the paginator's sort order.
+func (p *Paginator) Prev(token string, slicep interface{}) (PageTokens, error) {
+	return p.page(token, true, slicep)
+}
+
+func (p *Paginator) page(token string, backward bool, slicep interface{}) (PageTokens, error) {
+	pv := reflect.ValueOf(slicep)
+	if pv.Kind() != reflect.Ptr || pv.Elem().Kind() != reflect.Slice {
+		panic("slicep must be pointer to slice")
+	}
+	if p.pageSize <= 0 {
+		return PageTokens{}, errors.New("mongo: page size must be positive")
+	}
+
+	q := p.query
+	q.Spec.Sort = p.sort
+	if backward {
+		q.Spec.Sort = reverseSort(p.sort)
+	}
+	if token != "" {
+		values, err := p.decodeToken(token)
+		if err != nil {
+			return PageTokens{}, err
+		}
+		q.Spec.Query = p.rangeFilter(values, backward)
+	}
+	// Fetch an extra document to determine if there is another page. The
+	// range filter replaces skip for pages after the first.
+	q.Options.Limit = p.pageSize + 1
+	q.Options.Skip = 0
+
+	cursor, err := q.Cursor()
+	if err != nil {
+		return PageTokens{}, err
+	}
+	defer cursor.Close()
+
+	var docs []Raw
+	for cursor.HasNext() {
+		var doc Raw
+		if err := cursor.Next(&doc); err != nil {
+			return PageTokens{}, err
+		}
+		docs = append(docs, doc)
+	}
+	more := len(docs) > p.pageSize
+	if more {
+		docs = docs[:p.pageSize]
+	}
+	if backward {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+
+	v := reflect.MakeSlice(pv.Elem().Type(), len(docs), len(docs))
+	for i, doc := range docs {
+		if err := doc.Decode(v.Index(i)); err != nil {
+			return PageTokens{}, err
+		}
+	}
+	pv.Elem().Set(v)
+
+	// A token is returned for the direction of travel if there are more
+	// documents and for the opposite direction if the page starts at a token.
+	var tokens PageTokens
+	if len(docs) == 0 {
+		return tokens, nil
+	}
+	prev, next := token != "", more
+	if backward {
+		prev, next = next, prev
+	}
+	if prev {
+		if tokens.Prev, err = p.encodeToken(docs[0]); err != nil {
+			return PageTokens{}, err
+		}
+	}
+	if next {
+		if tokens.Next, err = p.encodeToken(docs[len(docs)-1]); err != nil {
+			return PageTokens{}, err
+		}
+	}
+	return tokens, nil
+}
+
+func reverseSort(sort D) D {
+	r := make(D, len(sort))
+	for i, item := range sort {
+		r[i] = DocItem{item.Key, -item.Value.(int)}
+	}
+	return r
+}
+
+// rangeFilter returns the query filter for the documents after (or before if
+// backward is true) the document with the given sort key values. For sort
+// keys k1, k2 in ascending order, the filter is:
+//
+//  {$or: [{k1: {$gt: v1}}, {k1: {$eq: v1}, k2: {$gt: v2}}]}
+//
+// The values are compared with $eq so that a value from a token is never
+// interpreted as a query operator.
+func (p *Paginator) rangeFilter(values []interface{}, backward bool) interface{} {
+	var or A
+	for i, item := range p.sort {
+		op := "$gt"
+		if (item.Value.(int) < 0) != backward {
+			op = "$lt"
+		}
+		var d D
+		for j := 0; j < i; j++ {
+			d.Append(p.sort[j].Key, D{{"$eq", values[j]}})
+		}
+		d.Append(item.Key, D{{op, values[i]}})
+		or = append(or, d)
+	}
+	filter := D{{"$or", or}}
+	if p.query.Spec.Query == nil {
+		return filter
+	}
+	return D{{"$and", A{p.query.Spec.Query, filter}}}
+}
+
+// encodeToken returns a token containing the sort key values of doc.
+func (p *Paginator) encodeToken(doc Raw) (string, error) {
+	values := make(A, len(p.sort))
+	for i, item := range p.sort {
+		v, err := doc.Lookup(item.Key)
+		if err != nil {
+			return "", fmt.Errorf("mongo: sort key %q not found in document", item.Key)
+		}
+		values[i] = v
+	}
+	data, err := Encode(nil, D{{"v", values}})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+var errBadToken = errors.New("mongo: invalid page token")
+
+// decodeToken returns the sort key values in token.
+func (p *Paginator) decodeToken(token string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errBadToken
+	}
+	var r struct {
+		V []interface{} `bson:"v"`
+	}
+	if err := Decode(data, &r); err != nil || len(r.V) != len(p.sort) {
+		return nil, errBadToken
+	}
+	for _, v := range r.V {
+		if hasOperator(v) {
+			return nil, errBadToken
+		}
+	}
+	return r.V, nil
+}
+
+// hasOperator returns true if v is or contains a document with a key that
+// starts with '$'.
+func hasOperator(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if strings.HasPrefix(k, "$") || hasOperator(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if hasOperator(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+var rangeFilterTests = []struct {
+	query    interface{}
+	sort     D
+	values   []interface{}
+	backward bool
+	filter   D
+}{
+	{
+		nil,
+		nil,
+		[]interface{}{3},
+		false,
+		D{{"$or", A{D{{"_id", D{{"$gt", 3}}}}}}},
+	},
+	{
+		nil,
+		D{{"_id", -1}},
+		[]interface{}{3},
+		true,
+		D{{"$or", A{D{{"_id", D{{"$gt", 3}}}}}}},
+	},
+	{
+		D{{"x", 1}},
+		D{{"a", 1}, {"b", -1}},
+		[]interface{}{1, "b", 3},
+		false,
+		D{{"$and", A{D{{"x", 1}}, D{{"$or", A{
+			D{{"a", D{{"$gt", 1}}}},
+			D{{"a", D{{"$eq", 1}}}, {"b", D{{"$lt", "b"}}}},
+			D{{"a", D{{"$eq", 1}}}, {"b", D{{"$eq", "b"}}}, {"_id", D{{"$gt", 3}}}},
+		}}}}}},
+	},
+	{
+		nil,
+		D{{"a", 1}, {"b", -1}},
+		[]interface{}{1, "b", 3},
+		true,
+		D{{"$or", A{
+			D{{"a", D{{"$lt", 1}}}},
+			D{{"a", D{{"$eq", 1}}}, {"b", D{{"$gt", "b"}}}},
+			D{{"a", D{{"$eq", 1}}}, {"b", D{{"$eq", "b"}}}, {"_id", D{{"$lt", 3}}}},
+		}}},
+	},
+}
+
+func TestPaginatorRangeFilter(t *testing.T) {
+	for _, tt := range rangeFilterTests {
+		q := &Query{Spec: QuerySpec{Query: tt.query}}
+		p := q.Paginate(tt.sort, 10)
+		actual, err := Encode(nil, p.rangeFilter(tt.values, tt.backward))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := Encode(nil, tt.filter)
+		if !bytes.Equal(actual, expected) {
+			var m M
+			Decode(actual, &m)
+			t.Errorf("rangeFilter(%v, %v, %v) = %v, want %v", tt.sort, tt.values, tt.backward, m, tt.filter)
+		}
+	}
+}
+
+func TestPaginatorPages(t *testing.T) {
+	c := newReplyConnection(nil)
+	c.serverInfo.MaxWireVersion = 6
+	var (
+		docs []interface{}
+		cmd  M
+	)
+	c.conn.(*replyConn).handler = func(query M) interface{} {
+		cmd = query
+		return D{{"ok", 1}, {"cursor", D{{"id", int64(0)}, {"ns", "db.c"}, {"firstBatch", A(docs)}}}}
+	}
+	type doc struct {
+		Id int `bson:"_id"`
+		N  int `bson:"n"`
+	}
+	q := &Query{Conn: c, Namespace: "db.c"}
+	p := q.Skip(40).Paginate(D{{"n", -1}}, 2)
+
+	// First page with more documents.
+	docs = []interface{}{doc{1, 30}, doc{2, 20}, doc{3, 10}}
+	var page []doc
+	tokens, err := p.Next("", &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []doc{{1, 30}, {2, 20}}; !reflect.DeepEqual(page, expected) {
+		t.Errorf("page = %v, want %v", page, expected)
+	}
+	if cmd["limit"] != 3 || cmd["skip"] != nil || cmd["filter"] != nil {
+		t.Errorf("first page command = %v", cmd)
+	}
+	if tokens.Prev != "" || tokens.Next == "" {
+		t.Fatalf("first page tokens = %+v", tokens)
+	}
+	values, err := p.decodeToken(tokens.Next)
+	if err != nil || !reflect.DeepEqual(values, []interface{}{20, 2}) {
+		t.Errorf("decodeToken(next) = %v, %v, want [20 2]", values, err)
+	}
+
+	// Last page.
+	docs = []interface{}{doc{3, 10}}
+	tokens, err = p.Next(tokens.Next, &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []doc{{3, 10}}; !reflect.DeepEqual(page, expected) {
+		t.Errorf("page = %v, want %v", page, expected)
+	}
+	if cmd["filter"] == nil || cmd["skip"] != nil {
+		t.Errorf("next page command = %v, want filter and no skip", cmd)
+	}
+	if tokens.Prev == "" || tokens.Next != "" {
+		t.Fatalf("last page tokens = %+v", tokens)
+	}
+
+	// Backward from the last page. The server returns documents in reverse
+	// order.
+	docs = []interface{}{doc{2, 20}, doc{1, 30}}
+	tokens, err = p.Prev(tokens.Prev, &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []doc{{1, 30}, {2, 20}}; !reflect.DeepEqual(page, expected) {
+		t.Errorf("page = %v, want %v", page, expected)
+	}
+	if sort, _ := cmd["sort"].(map[string]interface{}); sort["n"] != 1 || sort["_id"] != -1 {
+		t.Errorf("prev page sort = %v, want reversed", cmd["sort"])
+	}
+	if tokens.Prev != "" || tokens.Next == "" {
+		t.Fatalf("prev page tokens = %+v", tokens)
+	}
+
+	for _, token := range []string{"!", "AAAA", tokens.Next[:len(tokens.Next)-4]} {
+		if _, err := p.Next(token, &page); err == nil {
+			t.Errorf("Next(%q) did not return error", token)
+		}
+	}
+
+	for _, pageSize := range []int{0, -1} {
+		if _, err := q.Paginate(nil, pageSize).Next("", &page); err == nil {
+			t.Errorf("Next() with page size %d did not return error", pageSize)
+		}
+	}
+}
+
+func TestPaginatorMaliciousToken(t *testing.T) {
+	p := (&Query{}).Paginate(D{{"owner", 1}}, 10)
+	for _, values := range []A{
+		{D{{"$regex", "^(a+)+$"}}, 1},
+		{D{{"a", D{{"$where", "sleep(1000)"}}}}, 1},
+		{A{D{{"$ne", 1}}}, 1},
+	} {
+		data, err := Encode(nil, D{{"v", values}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := base64.RawURLEncoding.EncodeToString(data)
+		if _, err := p.decodeToken(token); err != errBadToken {
+			t.Errorf("decodeToken(%v) returned %v, want errBadToken", values, err)
+		}
+	}
+
+	// Documents without operators are compared with $eq.
+	data, _ := Encode(nil, D{{"v", A{D{{"name", "x"}}, 1}}})
+	values, err := p.decodeToken(base64.RawURLEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, _ := Encode(nil, p.rangeFilter(values, false))
+	expected, _ := Encode(nil, D{{"$or", A{
+		D{{"owner", D{{"$gt", D{{"name", "x"}}}}}},
+		D{{"owner", D{{"$eq", D{{"name", "x"}}}}}, {"_id", D{{"$gt", 1}}}},
+	}}})
+	if !bytes.Equal(actual, expected) {
+		var m M
+		Decode(actual, &m)
+		t.Errorf("rangeFilter() = %v", m)
+	}
+}
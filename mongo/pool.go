@@ -109,3 +109,7 @@ func (c *pooledConnection) Close() error {
 	c.Conn = nil
 	return nil
 }
+
+func (c *pooledConnection) ServerInfo() ServerInfo {
+	return serverInfo(c.Conn)
+}
@@ -14,7 +14,10 @@
 
 package mongo
 
-import "reflect"
+import (
+	"reflect"
+	"time"
+)
 
 // Query represents a query to the database.
 type Query struct {
@@ -52,6 +55,81 @@ type QuerySpec struct {
 	// See http://www.mongodb.org/display/DOCS/min+and+max+Query+Specifiers
 	Min interface{} `bson:"$min"`
 	Max interface{} `bson:"$max"`
+
+	// Comment attached to the query. The comment appears in the profiler and
+	// server logs.
+	Comment interface{} `bson:"$comment,omitempty"`
+
+	// Maximum time in milliseconds that the server allows the query to run.
+	MaxTimeMS int64 `bson:"$maxTimeMS,omitempty"`
+
+	// If set to true, then the query returns the index keys only.
+	ReturnKey bool `bson:"$returnKey,omitempty"`
+
+	// If set to true, then the query adds the record identifier to each
+	// document.
+	ShowRecordId bool `bson:"$showDiskLoc,omitempty"`
+
+	// The following fields are supported by the find command only. Queries
+	// that set these fields return an error on servers that do not support
+	// the find command.
+
+	// Read concern for the query.
+	ReadConcern *ReadConcern `bson:"-"`
+
+	// Collation for string comparisons.
+	Collation *Collation `bson:"-"`
+
+	// If set to true, then the server can write temporary data to disk when
+	// sorting.
+	AllowDiskUse bool `bson:"-"`
+
+	// Variables that can be referenced from $expr in the filter.
+	Let interface{} `bson:"-"`
+
+	// Maximum time in milliseconds that the server waits for new documents
+	// to satisfy a getMore on a tailable cursor with AwaitData set. Older
+	// servers ignore this field.
+	MaxAwaitTimeMS int64 `bson:"-"`
+}
+
+// ReadConcern specifies the isolation level for reads.
+//
+// More information: http://docs.mongodb.org/manual/reference/read-concern/
+type ReadConcern struct {
+	Level string `bson:"level,omitempty"`
+}
+
+// MaxAwaitTime specifies the maximum time that the server waits for new
+// documents on a tailable cursor with AwaitData set. The time is rounded down
+// to the nearest millisecond. Positive times less than one millisecond are
+// rounded up to one millisecond.
+func (q *Query) MaxAwaitTime(d time.Duration) *Query {
+	q.Spec.MaxAwaitTimeMS = durationMS(d)
+	return q
+}
+
+// durationMS returns d in milliseconds rounded down. Positive durations less
+// than a millisecond return 1 because the server treats 0 as no limit.
+func durationMS(d time.Duration) int64 {
+	if d > 0 && d < time.Millisecond {
+		return 1
+	}
+	return int64(d / time.Millisecond)
+}
+
+// Collation specifies language specific rules for string comparison.
+//
+// More information: http://docs.mongodb.org/manual/reference/collation/
+type Collation struct {
+	Locale          string `bson:"locale"`
+	CaseLevel       bool   `bson:"caseLevel,omitempty"`
+	CaseFirst       string `bson:"caseFirst,omitempty"`
+	Strength        int    `bson:"strength,omitempty"`
+	NumericOrdering bool   `bson:"numericOrdering,omitempty"`
+	Alternate       string `bson:"alternate,omitempty"`
+	MaxVariable     string `bson:"maxVariable,omitempty"`
+	Backwards       bool   `bson:"backwards,omitempty"`
 }
 
 // Sort specifies the sort order for the result. The order is specified by
@@ -130,7 +208,8 @@ func (q *Query) Exhaust(exhaust bool) *Query {
 }
 
 // Tailable specifies if the server should not close the cursor when no more
-// data is available.
+// data is available. See the Tail method for a helper that waits for new
+// data and recreates the cursor when it is lost.
 //
 // More information: http://www.mongodb.org/display/DOCS/Tailable+Cursors
 func (q *Query) Tailable(tailable bool) *Query {
@@ -138,6 +217,65 @@ func (q *Query) Tailable(tailable bool) *Query {
 	return q
 }
 
+// Comment attaches a comment to the query. The comment appears in the
+// profiler and server logs. The comment is also sent with the count,
+// distinct and findAndModify commands.
+func (q *Query) Comment(comment interface{}) *Query {
+	q.Spec.Comment = comment
+	return q
+}
+
+// MaxTime specifies the maximum time that the server allows the query to run.
+// The time is rounded down to the nearest millisecond. Positive times less
+// than one millisecond are rounded up to one millisecond.
+func (q *Query) MaxTime(d time.Duration) *Query {
+	q.Spec.MaxTimeMS = durationMS(d)
+	return q
+}
+
+// Collation specifies language specific rules for string comparison. The
+// collation requires server support for the find command.
+//
+// More information: http://docs.mongodb.org/manual/reference/collation/
+func (q *Query) Collation(collation *Collation) *Query {
+	q.Spec.Collation = collation
+	return q
+}
+
+// Let specifies variables that can be referenced from $expr in the filter.
+// The variables require server support for the find command.
+func (q *Query) Let(vars interface{}) *Query {
+	q.Spec.Let = vars
+	return q
+}
+
+// ReadConcern sets the read concern for the query. The read concern requires
+// server support for the find command.
+func (q *Query) ReadConcern(level string) *Query {
+	q.Spec.ReadConcern = &ReadConcern{Level: level}
+	return q
+}
+
+// AllowDiskUse specifies if the server can write temporary data to disk when
+// sorting. This option requires server support for the find command.
+func (q *Query) AllowDiskUse(allow bool) *Query {
+	q.Spec.AllowDiskUse = allow
+	return q
+}
+
+// ReturnKey specifies if the query returns the index keys only.
+func (q *Query) ReturnKey(returnKey bool) *Query {
+	q.Spec.ReturnKey = returnKey
+	return q
+}
+
+// ShowRecordId specifies if the server adds the record identifier to each
+// returned document.
+func (q *Query) ShowRecordId(show bool) *Query {
+	q.Spec.ShowRecordId = show
+	return q
+}
+
 // commandOptions returns copy of options with values set appropriately for
 // running a command.
 func commandOptions(options *FindOptions) *FindOptions {
@@ -148,6 +286,20 @@ func commandOptions(options *FindOptions) *FindOptions {
 	return &o
 }
 
+// appendCommandOptions appends the query's comment, time limit and collation
+// to a count, distinct or findAndModify command.
+func (q *Query) appendCommandOptions(cmd *D) {
+	if q.Spec.Comment != nil {
+		cmd.Append("comment", q.Spec.Comment)
+	}
+	if q.Spec.MaxTimeMS > 0 {
+		cmd.Append("maxTimeMS", q.Spec.MaxTimeMS)
+	}
+	if q.Spec.Collation != nil {
+		cmd.Append("collation", q.Spec.Collation)
+	}
+}
+
 // Count returns the number of documents that match the query. Limit and
 // skip are considered in the count.
 func (q *Query) Count() (int64, error) {
@@ -162,6 +314,7 @@ func (q *Query) Count() (int64, error) {
 	if q.Options.Skip != 0 {
 		cmd.Append("skip", q.Options.Skip)
 	}
+	q.appendCommandOptions(&cmd)
 	var r struct {
 		CommandResponse
 		N int64 `bson:"n"`
@@ -180,17 +333,34 @@ func (q *Query) simplifyQuery() interface{} {
 		q.Spec.Hint == nil &&
 		q.Spec.Snapshot == false &&
 		q.Spec.Min == nil &&
-		q.Spec.Max == nil {
+		q.Spec.Max == nil &&
+		q.Spec.Comment == nil &&
+		q.Spec.MaxTimeMS == 0 &&
+		q.Spec.ReturnKey == false &&
+		q.Spec.ShowRecordId == false {
 		return q.Spec.Query
 	}
 	return &q.Spec
 }
 
+// cursor executes the query with the given options. The find command is used
+// when supported by the server. Otherwise, the query is sent to the server
+// with an OP_QUERY message.
+func (q *Query) cursor(options *FindOptions) (Cursor, error) {
+	if q.useFindCommand() {
+		return q.findCursor(options)
+	}
+	if err := q.checkLegacy(); err != nil {
+		return nil, err
+	}
+	return q.Conn.Find(q.Namespace, q.simplifyQuery(), options)
+}
+
 // One executes the query and returns the first result.
 func (q *Query) One(output interface{}) error {
 	q.Options.Limit = 1
 	q.Options.BatchSize = -1
-	cursor, err := q.Conn.Find(q.Namespace, q.simplifyQuery(), &q.Options)
+	cursor, err := q.cursor(&q.Options)
 	if err != nil {
 		return err
 	}
@@ -201,7 +371,31 @@ func (q *Query) One(output interface{}) error {
 // Cursor executes the query and returns a cursor over the results. Subsequent
 // changes to the query object are ignored by the cursor.
 func (q *Query) Cursor() (Cursor, error) {
-	return q.Conn.Find(q.Namespace, q.simplifyQuery(), &q.Options)
+	return q.cursor(&q.Options)
+}
+
+// ForEach executes the query and calls fn for each document in the result
+// set. If fn returns an error, then ForEach stops and returns the error. Use
+// Done to stop without an error.
+func (q *Query) ForEach(fn func(BSONData) error) error {
+	cursor, err := q.cursor(&q.Options)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for cursor.HasNext() {
+		var bd BSONData
+		if err := cursor.Next(&bd); err != nil {
+			return err
+		}
+		if err := fn(bd); err != nil {
+			if err == Done {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
 }
 
 // Fill executes the query and copies up to len(slice) documents to slice. The
@@ -213,7 +407,7 @@ func (q *Query) Fill(slice interface{}) (n int, err error) {
 	if q.Options.Limit == 0 || q.Options.Limit > v.Len() {
 		q.Options.Limit = v.Len()
 	}
-	cursor, err := q.Conn.Find(q.Namespace, q.simplifyQuery(), &q.Options)
+	cursor, err := q.cursor(&q.Options)
 	if err != nil {
 		return 0, err
 	}
@@ -236,7 +430,7 @@ func (q *Query) All(slicep interface{}) error {
 		panic("slicep must be pointer to slice")
 	}
 
-	cursor, err := q.Conn.Find(q.Namespace, q.simplifyQuery(), &q.Options)
+	cursor, err := q.cursor(&q.Options)
 	if err != nil {
 		return err
 	}
@@ -268,6 +462,14 @@ func (q *Query) All(slicep interface{}) error {
 //
 // More information: http://www.mongodb.org/display/DOCS/Optimization#Optimization-Explain
 func (q *Query) Explain(result interface{}) error {
+	if q.useFindCommand() {
+		dbname, _ := SplitNamespace(q.Namespace)
+		cmd := D{{"explain", q.findCommand(&q.Options)}}
+		return runInternal(q.Conn, dbname, cmd, commandOptions(&q.Options), result)
+	}
+	if err := q.checkLegacy(); err != nil {
+		return err
+	}
 	spec := q.Spec
 	spec.Explain = true
 	options := q.Options
@@ -292,6 +494,7 @@ func (q *Query) Distinct(key interface{}, result interface{}) error {
 	if q.Spec.Query != nil {
 		cmd.Append("query", &q.Spec.Query)
 	}
+	q.appendCommandOptions(&cmd)
 	var r struct {
 		CommandResponse
 		Values interface{} `bson:"values"`
@@ -303,48 +506,111 @@ func (q *Query) Distinct(key interface{}, result interface{}) error {
 	return r.Err()
 }
 
+// FindAndModifyOptions specifies options for the Query.FindAndModify method.
+type FindAndModifyOptions struct {
+	// The update document or, for servers that support aggregation pipeline
+	// updates, an array of pipeline stages. Update is ignored if Remove is
+	// true.
+	Update interface{}
+
+	// If true, then the matching document is removed.
+	Remove bool
+
+	// If true, then the update is inserted when no document matches the
+	// query.
+	Upsert bool
+
+	// If true, then the modified document is returned instead of the
+	// original document.
+	New bool
+
+	// Filters that determine which array elements are modified by the
+	// filtered positional operator $[<identifier>].
+	ArrayFilters interface{}
+
+	// If true, then the update is allowed to bypass document validation.
+	BypassDocumentValidation bool
+
+	// Optional write concern for the command.
+	WriteConcern *WriteConcern
+}
+
+// WriteConcern specifies the acknowledgment requested from the server for a
+// write operation.
+//
+// More information: http://docs.mongodb.org/manual/reference/write-concern/
+type WriteConcern struct {
+	// The number of servers or the tag set that must acknowledge the write.
+	W interface{} `bson:"w,omitempty"`
+
+	// If true, then the write must be written to the journal.
+	J bool `bson:"j,omitempty"`
+
+	// Time limit in milliseconds for the write concern.
+	WTimeout int `bson:"wtimeout,omitempty"`
+}
+
+// FindAndModifyResult is the lastErrorObject returned by the findAndModify
+// command.
+type FindAndModifyResult struct {
+	// The number of documents matched or inserted.
+	N int `bson:"n"`
+
+	// True if an existing document was updated.
+	Updated bool `bson:"updatedExisting"`
+
+	// The _id of the inserted document if the update was an upsert.
+	UpsertedId interface{} `bson:"upserted"`
+}
+
 // Remove returns the first document matching the query after removing the
 // document from the database. Use the Sort method to specify the sort order
 // for matching the documents and the Fields method to specify the returned
-// fields.
+// fields. If no document matches the query, then ErrNotFound is returned.
 //
 // Remove is a wrapper around the MongoDB findAndModify command.
 func (q *Query) Remove(result interface{}) error {
-	_, name := SplitNamespace(q.Namespace)
-	return q.findAndModify(
-		D{{"findAndModify", name}, {"remove", true}},
-		result)
+	_, err := q.FindAndModify(&FindAndModifyOptions{Remove: true}, result)
+	return err
 }
 
 // Update updates the first document matching the query.  The modified document
 // is returned if modified is true, otherwise the original document is
 // returned.  Use the Sort method to specify the sort order for matching the
-// documents and the Fields method to specify the returned fields.
+// documents and the Fields method to specify the returned fields. If no
+// document matches the query, then ErrNotFound is returned.
 //
 // Update is a wrapper around the MongoDB findAndModify command.
 func (q *Query) Update(update interface{}, modified bool, result interface{}) error {
-	_, name := SplitNamespace(q.Namespace)
-	return q.findAndModify(
-		D{{"findAndModify", name}, {"update", update}, {"new", modified}},
-		result)
+	_, err := q.FindAndModify(&FindAndModifyOptions{Update: update, New: modified}, result)
+	return err
 }
 
 // Upsert updates the first document matching the query. If a matching document
 // is not found, then the update is inserted instead. The modified document is
 // returned if modified is true, otherwise the original document is returned.
 // Use the Sort method to specify the sort order for matching the documents and
-// the Fields method to specify the returned fields.
+// the Fields method to specify the returned fields. If modified is false and
+// the update is inserted, then result is not modified and nil is returned.
 //
 // Upsert is a wrapper around the MongoDB findAndModify command.
 func (q *Query) Upsert(update interface{}, modified bool, result interface{}) error {
-	_, name := SplitNamespace(q.Namespace)
-	return q.findAndModify(
-		D{{"findAndModify", name}, {"update", update}, {"upsert", true}, {"new", modified}},
-		result)
+	_, err := q.FindAndModify(&FindAndModifyOptions{Update: update, Upsert: true, New: modified}, result)
+	return err
 }
 
-func (q *Query) findAndModify(cmd D, result interface{}) error {
-	dbname, _ := SplitNamespace(q.Namespace)
+// FindAndModify updates or removes the first document matching the query and
+// decodes the original or modified document to result. Use the Sort, Fields,
+// Hint, Comment, MaxTime, Collation and Let methods to specify the
+// corresponding options for the command. The function returns whether a
+// document was updated or inserted. If the command does not return a
+// document, then result is not modified. In that case, ErrNotFound is
+// returned unless the update was inserted.
+//
+// More information: http://docs.mongodb.org/manual/reference/command/findAndModify/
+func (q *Query) FindAndModify(options *FindAndModifyOptions, result interface{}) (*FindAndModifyResult, error) {
+	dbname, cname := SplitNamespace(q.Namespace)
+	cmd := D{{"findAndModify", cname}}
 	cmd.Append("query", q.Spec.Query)
 	if q.Spec.Sort != nil {
 		cmd.Append("sort", q.Spec.Sort)
@@ -352,14 +618,48 @@ func (q *Query) findAndModify(cmd D, result interface{}) error {
 	if q.Options.Fields != nil {
 		cmd.Append("fields", q.Options.Fields)
 	}
+	if options.Remove {
+		cmd.Append("remove", true)
+	} else {
+		cmd.Append("update", options.Update)
+		cmd.Append("new", options.New)
+		if options.Upsert {
+			cmd.Append("upsert", true)
+		}
+	}
+	if options.ArrayFilters != nil {
+		cmd.Append("arrayFilters", options.ArrayFilters)
+	}
+	if options.BypassDocumentValidation {
+		cmd.Append("bypassDocumentValidation", true)
+	}
+	if options.WriteConcern != nil {
+		cmd.Append("writeConcern", options.WriteConcern)
+	}
+	if q.Spec.Hint != nil {
+		cmd.Append("hint", q.Spec.Hint)
+	}
+	q.appendCommandOptions(&cmd)
+	if q.Spec.Let != nil {
+		cmd.Append("let", q.Spec.Let)
+	}
 	var r struct {
 		CommandResponse
-		Value interface{} `bson:"value"`
+		LastErrorObject FindAndModifyResult `bson:"lastErrorObject"`
+		Value           BSONData            `bson:"value"`
 	}
-	r.Value = result
 	err := runInternal(q.Conn, dbname, cmd, runFindOptions, &r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return r.Err()
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	if r.Value.Kind == 0 || r.Value.Kind == kindNull {
+		if r.LastErrorObject.UpsertedId != nil {
+			return &r.LastErrorObject, nil
+		}
+		return &r.LastErrorObject, ErrNotFound
+	}
+	return &r.LastErrorObject, r.Value.Decode(result)
 }
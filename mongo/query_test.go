@@ -15,7 +15,11 @@
 package mongo
 
 import (
+	"bytes"
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 )
 
 var countTests = []struct {
@@ -200,3 +204,195 @@ func TestFindAndModify(t *testing.T) {
 		t.Error("bad update did not return error")
 	}
 }
+
+func TestQueryCommandOptions(t *testing.T) {
+	c := newReplyConnection(nil)
+	var cmd M
+	c.conn.(*replyConn).handler = func(query M) interface{} {
+		cmd = query
+		return D{{"ok", 1}, {"n", 1}, {"values", A{}}, {"value", D{}}}
+	}
+	newQuery := func() *Query {
+		q := &Query{Conn: c, Namespace: "db.c"}
+		return q.Comment("report").MaxTime(1500 * time.Millisecond).Collation(&Collation{Locale: "fr"})
+	}
+	check := func(name string, let bool) {
+		expected := M{
+			"comment":   "report",
+			"maxTimeMS": int64(1500),
+			"collation": M{"locale": "fr"},
+		}
+		if let {
+			expected["let"] = M{"v": 1}
+		}
+		for k, v := range expected {
+			actual, err := Encode(nil, D{{"v", cmd[k]}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := Encode(nil, D{{"v", v}})
+			if !bytes.Equal(actual, want) {
+				t.Errorf("%s: %s = %v, want %v", name, k, cmd[k], v)
+			}
+		}
+	}
+
+	if _, err := newQuery().Count(); err != nil {
+		t.Fatal(err)
+	}
+	check("count", false)
+
+	var values []interface{}
+	if err := newQuery().Distinct("x", &values); err != nil {
+		t.Fatal(err)
+	}
+	check("distinct", false)
+
+	var m M
+	if err := newQuery().Let(D{{"v", 1}}).Remove(&m); err != nil {
+		t.Fatal(err)
+	}
+	check("findAndModify", true)
+}
+
+var findAndModifyTests = []struct {
+	options  FindAndModifyOptions
+	response D
+	cmd      D
+	result   FindAndModifyResult
+	err      error
+}{
+	{
+		FindAndModifyOptions{Remove: true},
+		D{{"ok", 1}, {"lastErrorObject", D{{"n", 1}}}, {"value", D{{"x", 1}}}},
+		D{{"findAndModify", "c"}, {"query", D{{"x", 1}}}, {"remove", true}},
+		FindAndModifyResult{N: 1},
+		nil,
+	},
+	{
+		FindAndModifyOptions{
+			Update:                   D{{"$set", D{{"a.$[e].y", 2}}}},
+			New:                      true,
+			ArrayFilters:             A{D{{"e.z", 3}}},
+			BypassDocumentValidation: true,
+			WriteConcern:             &WriteConcern{W: "majority"},
+		},
+		D{{"ok", 1}, {"lastErrorObject", D{{"n", 1}, {"updatedExisting", true}}}, {"value", D{{"x", 1}}}},
+		D{{"findAndModify", "c"}, {"query", D{{"x", 1}}},
+			{"update", D{{"$set", D{{"a.$[e].y", 2}}}}}, {"new", true},
+			{"arrayFilters", A{D{{"e.z", 3}}}},
+			{"bypassDocumentValidation", true},
+			{"writeConcern", D{{"w", "majority"}}}},
+		FindAndModifyResult{N: 1, Updated: true},
+		nil,
+	},
+	{
+		FindAndModifyOptions{Update: A{D{{"$set", D{{"y", "$x"}}}}}, Upsert: true},
+		D{{"ok", 1}, {"lastErrorObject", D{{"n", 1}, {"upserted", 7}}}, {"value", nil}},
+		D{{"findAndModify", "c"}, {"query", D{{"x", 1}}},
+			{"update", A{D{{"$set", D{{"y", "$x"}}}}}}, {"new", false}, {"upsert", true}},
+		FindAndModifyResult{N: 1, UpsertedId: 7},
+		nil,
+	},
+	{
+		FindAndModifyOptions{Update: D{{"$inc", D{{"n", 1}}}}},
+		D{{"ok", 1}, {"lastErrorObject", D{{"n", 0}}}},
+		D{{"findAndModify", "c"}, {"query", D{{"x", 1}}},
+			{"update", D{{"$inc", D{{"n", 1}}}}}, {"new", false}},
+		FindAndModifyResult{},
+		ErrNotFound,
+	},
+}
+
+func TestFindAndModifyOptions(t *testing.T) {
+	for _, tt := range findAndModifyTests {
+		c := newReplyConnection(nil)
+		var cmd []byte
+		c.conn.(*replyConn).handler = func(query M) interface{} {
+			cmd, _ = Encode(nil, query.D())
+			return tt.response
+		}
+		var m M
+		result, err := (&Query{Conn: c, Namespace: "db.c", Spec: QuerySpec{Query: D{{"x", 1}}}}).FindAndModify(&tt.options, &m)
+		if err != tt.err {
+			t.Errorf("FindAndModify(%+v) returned error %v, want %v", tt.options, err, tt.err)
+			continue
+		}
+		if result == nil || !reflect.DeepEqual(*result, tt.result) {
+			t.Errorf("FindAndModify(%+v) = %+v, want %+v", tt.options, result, tt.result)
+		}
+		expected, _ := Encode(nil, tt.cmd.M().D())
+		if !bytes.Equal(cmd, expected) {
+			var actual M
+			Decode(cmd, &actual)
+			t.Errorf("FindAndModify(%+v) sent %v, want %v", tt.options, actual, tt.cmd)
+		}
+		if err == nil && tt.result.UpsertedId == nil && m["x"] != 1 {
+			t.Errorf("FindAndModify(%+v) decoded %v, want x: 1", tt.options, m)
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	var docs []interface{}
+	for i := 0; i < 5; i++ {
+		docs = append(docs, D{{"x", i}})
+	}
+	errStop := errors.New("stop")
+	tests := []struct {
+		stop error
+		n    int
+		err  error
+	}{
+		{nil, 5, nil},
+		{Done, 3, nil},
+		{errStop, 3, errStop},
+	}
+	for _, tt := range tests {
+		var commands []string
+		c := newFindConnection(docs, 2, &commands)
+		n := 0
+		err := (&Query{Conn: c, Namespace: "db.c"}).ForEach(func(bd BSONData) error {
+			var m M
+			if err := bd.Decode(&m); err != nil {
+				return err
+			}
+			if m["x"] != n {
+				t.Errorf("document %d = %v", n, m)
+			}
+			n++
+			if n == 3 && tt.stop != nil {
+				return tt.stop
+			}
+			return nil
+		})
+		if n != tt.n || err != tt.err {
+			t.Errorf("stop=%v, ForEach() called fn %d times and returned %v, want %d, %v", tt.stop, n, err, tt.n, tt.err)
+		}
+	}
+}
+
+var maxTimeTests = []struct {
+	d  time.Duration
+	ms int64
+}{
+	{0, 0},
+	{-time.Second, -1000},
+	{time.Nanosecond, 1},
+	{999 * time.Microsecond, 1},
+	{time.Millisecond, 1},
+	{1999 * time.Microsecond, 1},
+	{time.Second, 1000},
+}
+
+func TestMaxTime(t *testing.T) {
+	for _, tt := range maxTimeTests {
+		q := (&Query{}).MaxTime(tt.d).MaxAwaitTime(tt.d)
+		if q.Spec.MaxTimeMS != tt.ms {
+			t.Errorf("MaxTime(%v) set MaxTimeMS = %d, want %d", tt.d, q.Spec.MaxTimeMS, tt.ms)
+		}
+		if q.Spec.MaxAwaitTimeMS != tt.ms {
+			t.Errorf("MaxAwaitTime(%v) set MaxAwaitTimeMS = %d, want %d", tt.d, q.Spec.MaxAwaitTimeMS, tt.ms)
+		}
+	}
+}
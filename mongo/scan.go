@@ -0,0 +1,245 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"errors"
+)
+
+// samplesPerCursor is the number of documents sampled per cursor when the
+// splitVector command is not available.
+const samplesPerCursor = 20
+
+// ParallelScan splits the collection into up to n ranges of _id values and
+// returns a cursor for each range. Together, the cursors return every
+// document in the collection once.
+//
+// The range boundaries are found with the splitVector command. If the command
+// is not available, then the boundaries are found by sampling the collection
+// with the $sample aggregation stage. The cursors use the min and max query
+// specifiers with the _id index.
+//
+// If the collection's connection was obtained from a Pool, then each cursor
+// uses a separate connection from the pool and the cursors can be read
+// concurrently. The connection is returned to the pool when the cursor is
+// closed. Otherwise, the cursors share the collection's connection and must
+// not be read concurrently.
+//
+// If a cursor cannot be created, then the cursors are closed and the errors
+// from all ranges are returned together.
+func (c Collection) ParallelScan(n int) ([]Cursor, error) {
+	if n < 1 {
+		return nil, errors.New("mongo: parallel scan count must be positive")
+	}
+	bounds, err := c.scanBoundaries(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		cursors []Cursor
+		errs    []error
+	)
+	for i := 0; i <= len(bounds); i++ {
+		q := &Query{Conn: c.Conn, Namespace: c.Namespace}
+		q.Spec.Hint = D{{"_id", 1}}
+		if i > 0 {
+			q.Spec.Min = D{{"_id", bounds[i-1]}}
+		}
+		if i < len(bounds) {
+			q.Spec.Max = D{{"_id", bounds[i]}}
+		}
+		cursor, err := scanCursor(q)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cursors = append(cursors, cursor)
+	}
+	if len(errs) > 0 {
+		for _, cursor := range cursors {
+			cursor.Close()
+		}
+		return nil, errors.Join(errs...)
+	}
+	return cursors, nil
+}
+
+// pooledCursor closes the cursor's pooled connection when the cursor is
+// closed.
+type pooledCursor struct {
+	Cursor
+	conn Conn
+}
+
+func (c *pooledCursor) Close() error {
+	err := c.Cursor.Close()
+	c.conn.Close()
+	return err
+}
+
+// scanCursor executes q on a separate pooled connection if q's connection
+// is from a pool.
+func scanCursor(q *Query) (Cursor, error) {
+	pc, ok := q.Conn.(*pooledConnection)
+	if !ok {
+		return q.Cursor()
+	}
+	conn, err := pc.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	q.Conn = conn
+	cursor, err := q.Cursor()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &pooledCursor{Cursor: cursor, conn: conn}
+	if bc, ok := cursor.(BatchCursor); ok {
+		return &pooledBatchCursor{c, bc}, nil
+	}
+	return c, nil
+}
+
+// pooledBatchCursor is a pooledCursor for a cursor that implements
+// BatchCursor.
+type pooledBatchCursor struct {
+	*pooledCursor
+	bc BatchCursor
+}
+
+func (c *pooledBatchCursor) NextBatch() ([]BSONData, error) {
+	return c.bc.NextBatch()
+}
+
+func (c *pooledBatchCursor) RemainingInBatch() int {
+	return c.bc.RemainingInBatch()
+}
+
+func (c *pooledBatchCursor) ID() int64 {
+	return c.bc.ID()
+}
+
+// scanKey is an _id value returned by the splitVector command or the $sample
+// aggregation.
+type scanKey struct {
+	Id BSONData `bson:"_id"`
+}
+
+// scanBoundaries returns up to n-1 _id values that split the collection into
+// ranges of approximately equal size.
+func (c Collection) scanBoundaries(n int) ([]BSONData, error) {
+	if n == 1 {
+		return nil, nil
+	}
+	keys, err := c.splitVector(n)
+	if err != nil {
+		var sampleErr error
+		keys, sampleErr = c.sampleKeys(n)
+		if sampleErr != nil {
+			return nil, errors.Join(err, sampleErr)
+		}
+	}
+	return selectBoundaries(keys, n), nil
+}
+
+// splitVector returns the split points computed by the splitVector command.
+//
+// More information: http://docs.mongodb.org/manual/reference/command/splitVector/
+func (c Collection) splitVector(n int) ([]scanKey, error) {
+	dbname, cname := SplitNamespace(c.Namespace)
+	var stats struct {
+		CommandResponse
+		Size  int64 `bson:"size"`
+		Count int64 `bson:"count"`
+	}
+	if err := runInternal(c.Conn, dbname, D{{"collStats", cname}}, runFindOptions, &stats); err != nil {
+		return nil, err
+	}
+	if err := stats.Err(); err != nil {
+		return nil, err
+	}
+
+	// The server splits chunks at half of the maximum chunk size.
+	cmd := D{
+		{"splitVector", c.Namespace},
+		{"keyPattern", D{{"_id", 1}}},
+		{"maxChunkSizeBytes", 2*stats.Size/int64(n) + 1},
+		{"maxChunkObjects", 2*stats.Count/int64(n) + 1},
+	}
+	var r struct {
+		CommandResponse
+		SplitKeys []scanKey `bson:"splitKeys"`
+	}
+	if err := runInternal(c.Conn, dbname, cmd, runFindOptions, &r); err != nil {
+		return nil, err
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return r.SplitKeys, nil
+}
+
+// sampleKeys returns the sorted _id values of a random sample of documents
+// in the collection.
+func (c Collection) sampleKeys(n int) ([]scanKey, error) {
+	dbname, cname := SplitNamespace(c.Namespace)
+	size := n * samplesPerCursor
+	cmd := D{
+		{"aggregate", cname},
+		{"pipeline", A{
+			D{{"$sample", D{{"size", size}}}},
+			D{{"$project", D{{"_id", 1}}}},
+			D{{"$sort", D{{"_id", 1}}}},
+		}},
+		{"cursor", D{{"batchSize", size}}},
+	}
+	var r struct {
+		CommandResponse
+		Cursor struct {
+			FirstBatch []scanKey `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+	if err := runInternal(c.Conn, dbname, cmd, runFindOptions, &r); err != nil {
+		return nil, err
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return r.Cursor.FirstBatch, nil
+}
+
+// selectBoundaries returns up to n-1 evenly spaced distinct values from the
+// sorted keys.
+func selectBoundaries(keys []scanKey, n int) []BSONData {
+	var bounds []BSONData
+	for i := 1; i < n; i++ {
+		j := i * len(keys) / n
+		if j >= len(keys) {
+			break
+		}
+		k := keys[j].Id
+		if len(bounds) > 0 {
+			last := bounds[len(bounds)-1]
+			if last.Kind == k.Kind && bytes.Equal(last.Data, k.Data) {
+				continue
+			}
+		}
+		bounds = append(bounds, k)
+	}
+	return bounds
+}
@@ -0,0 +1,175 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func scanKeys(ids ...int) []scanKey {
+	var keys []scanKey
+	for _, id := range ids {
+		var k scanKey
+		data, _ := Encode(nil, D{{"_id", id}})
+		Decode(data, &k)
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+var selectBoundariesTests = []struct {
+	ids    []int
+	n      int
+	bounds []int
+}{
+	{nil, 4, nil},
+	{[]int{1, 2, 3, 4, 5, 6, 7, 8}, 4, []int{3, 5, 7}},
+	{[]int{1, 2, 3, 4, 5, 6, 7, 8}, 2, []int{5}},
+	{[]int{1, 2}, 4, []int{1, 2}},
+	{[]int{1, 1, 1, 1, 2, 2}, 3, []int{1, 2}},
+}
+
+func TestSelectBoundaries(t *testing.T) {
+	for _, tt := range selectBoundariesTests {
+		var bounds []int
+		for _, bd := range selectBoundaries(scanKeys(tt.ids...), tt.n) {
+			var id int
+			bd.Decode(&id)
+			bounds = append(bounds, id)
+		}
+		if !reflect.DeepEqual(bounds, tt.bounds) {
+			t.Errorf("selectBoundaries(%v, %d) = %v, want %v", tt.ids, tt.n, bounds, tt.bounds)
+		}
+	}
+}
+
+// scanServer is a fake server for parallel scans. The server records the min
+// and max specifiers of find commands.
+type scanServer struct {
+	mu          sync.Mutex
+	splitVector bool
+	commands    []string
+	ranges      []string
+}
+
+func (s *scanServer) handler(query M) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range []string{"collStats", "splitVector", "aggregate", "find"} {
+		if query[name] != nil {
+			s.commands = append(s.commands, name)
+		}
+	}
+	keys := A{D{{"_id", 10}}, D{{"_id", 20}}, D{{"_id", 30}}}
+	switch {
+	case query["collStats"] != nil:
+		return D{{"ok", 1}, {"size", 3000}, {"count", 30}}
+	case query["splitVector"] != nil:
+		if !s.splitVector {
+			return D{{"ok", 0}, {"errmsg", "no such command: 'splitVector'"}}
+		}
+		return D{{"ok", 1}, {"splitKeys", keys}}
+	case query["aggregate"] != nil:
+		return D{{"ok", 1}, {"cursor", D{{"id", int64(0)}, {"firstBatch", keys}}}}
+	case query["find"] != nil:
+		s.ranges = append(s.ranges, fmt.Sprint(query["min"], query["max"]))
+		return D{{"ok", 1}, {"cursor", D{{"id", int64(0)}, {"firstBatch", A{D{{"_id", 1}}}}}}}
+	}
+	return D{{"ok", 0}, {"errmsg", "unknown command"}}
+}
+
+func (s *scanServer) newConnection() *connection {
+	c := newReplyConnection(nil)
+	c.serverInfo.MaxWireVersion = 6
+	c.conn.(*replyConn).handler = s.handler
+	return c
+}
+
+func TestParallelScan(t *testing.T) {
+	expectedRanges := []string{
+		"<nil> map[_id:10]",
+		"map[_id:10] map[_id:20]",
+		"map[_id:20] map[_id:30]",
+		"map[_id:30] <nil>",
+	}
+	for _, splitVector := range []bool{true, false} {
+		s := &scanServer{splitVector: splitVector}
+		c := Collection{Conn: s.newConnection(), Namespace: "db.c"}
+		cursors, err := c.ParallelScan(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cursors) != 4 {
+			t.Fatalf("splitVector=%v, len(cursors) = %d, want 4", splitVector, len(cursors))
+		}
+		for _, cursor := range cursors {
+			cursor.Close()
+		}
+		if !reflect.DeepEqual(s.ranges, expectedRanges) {
+			t.Errorf("splitVector=%v, ranges = %q, want %q", splitVector, s.ranges, expectedRanges)
+		}
+		expectedCommands := []string{"collStats", "splitVector", "find", "find", "find", "find"}
+		if !splitVector {
+			expectedCommands = []string{"collStats", "splitVector", "aggregate", "find", "find", "find", "find"}
+		}
+		if !reflect.DeepEqual(s.commands, expectedCommands) {
+			t.Errorf("splitVector=%v, commands = %v, want %v", splitVector, s.commands, expectedCommands)
+		}
+	}
+}
+
+func TestParallelScanPool(t *testing.T) {
+	s := &scanServer{splitVector: true}
+	var conns []*connection
+	pool := NewPool(func() (Conn, error) {
+		c := s.newConnection()
+		conns = append(conns, c)
+		return c, nil
+	}, 10)
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cursors, err := Collection{Conn: conn, Namespace: "db.c"}.ParallelScan(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 5 {
+		t.Errorf("created %d connections, want 5", len(conns))
+	}
+	var wg sync.WaitGroup
+	for _, cursor := range cursors {
+		wg.Add(1)
+		go func(cursor Cursor) {
+			defer wg.Done()
+			defer cursor.Close()
+			for cursor.HasNext() {
+				var m M
+				if err := cursor.Next(&m); err != nil {
+					t.Error(err)
+				}
+			}
+		}(cursor)
+	}
+	wg.Wait()
+	if n := len(pool.conns); n != 4 {
+		t.Errorf("%d idle connections in pool, want 4", n)
+	}
+}
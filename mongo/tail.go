@@ -0,0 +1,167 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TailOptions specifies options for the Query.Tail method.
+type TailOptions struct {
+	// Name of the field used to resume the query when the cursor is lost.
+	// The field value must increase in insertion order. The default is
+	// "_id".
+	Field string
+
+	// Maximum time that the server waits for new documents before returning
+	// an empty batch. The default is one second.
+	MaxAwaitTime time.Duration
+
+	// Minimum and maximum time to sleep when no documents are available. The
+	// sleep time doubles on each consecutive empty poll. The defaults are 100
+	// milliseconds and five seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+var defaultTailOptions = TailOptions{
+	Field:        "_id",
+	MaxAwaitTime: time.Second,
+	MinBackoff:   100 * time.Millisecond,
+	MaxBackoff:   5 * time.Second,
+}
+
+// Tail executes the query with a tailable cursor on a capped collection and
+// calls fn for each document in the result set as documents are inserted.
+//
+// When no documents are available, Tail sleeps before polling the server
+// again. If the server reports that the cursor is not found or closes the
+// cursor, then Tail executes the query again for documents with a value of
+// the resume field greater than the last document passed to fn.
+//
+// Tail returns when ctx is done, when fn returns an error or when the query
+// fails. If fn returns Done, then Tail returns nil. Tail checks ctx between
+// calls to the server. A call to the server blocks for up to MaxAwaitTime.
+func (q *Query) Tail(ctx context.Context, options *TailOptions, fn func(BSONData) error) error {
+	o := defaultTailOptions
+	if options != nil {
+		if options.Field != "" {
+			o.Field = options.Field
+		}
+		if options.MaxAwaitTime > 0 {
+			o.MaxAwaitTime = options.MaxAwaitTime
+		}
+		if options.MinBackoff > 0 {
+			o.MinBackoff = options.MinBackoff
+		}
+		if options.MaxBackoff > 0 {
+			o.MaxBackoff = options.MaxBackoff
+		}
+	}
+
+	var (
+		cursor  Cursor
+		last    BSONData
+		backoff = o.MinBackoff
+	)
+	defer func() {
+		if cursor != nil {
+			cursor.Close()
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if cursor == nil {
+			tq := *q
+			tq.Options.Tailable = true
+			tq.Options.AwaitData = true
+			tq.MaxAwaitTime(o.MaxAwaitTime)
+			if last.Kind != 0 {
+				tq.Spec.Query = resumeFilter(q.Spec.Query, o.Field, last)
+				tq.Options.Skip = 0
+			}
+			var err error
+			cursor, err = tq.cursor(&tq.Options)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cursor.HasNext() {
+			var bd BSONData
+			err := cursor.Next(&bd)
+			if err == ErrCursorNotFound {
+				cursor.Close()
+				cursor = nil
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			v, err := Raw(bd.Data).Lookup(o.Field)
+			if err != nil {
+				return fmt.Errorf("mongo: tail field %q not found in document", o.Field)
+			}
+			last = v
+			backoff = o.MinBackoff
+			if err := fn(bd); err != nil {
+				if err == Done {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+
+		switch err := cursor.Err(); err {
+		case nil:
+		case Done, ErrCursorNotFound:
+			// The server closed the cursor. The query is executed again
+			// after sleeping.
+			cursor.Close()
+			cursor = nil
+		default:
+			return err
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		backoff *= 2
+		if backoff > o.MaxBackoff {
+			backoff = o.MaxBackoff
+		}
+	}
+}
+
+// resumeFilter returns a filter for the documents matching query with field
+// greater than last.
+func resumeFilter(query interface{}, field string, last BSONData) interface{} {
+	filter := D{{field, D{{"$gt", last}}}}
+	if query == nil {
+		return filter
+	}
+	return D{{"$and", A{query, filter}}}
+}
@@ -0,0 +1,115 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var tailTestOptions = &TailOptions{
+	MaxAwaitTime: 50 * time.Millisecond,
+	MinBackoff:   time.Millisecond,
+	MaxBackoff:   2 * time.Millisecond,
+}
+
+func TestTail(t *testing.T) {
+	// Responses to the commands sent by Tail in order.
+	responses := []D{
+		// The first find returns two documents.
+		{{"ok", 1}, {"cursor", D{{"id", int64(42)}, {"firstBatch", A{D{{"_id", 1}}, D{{"_id", 2}}}}}}},
+		// No new documents.
+		{{"ok", 1}, {"cursor", D{{"id", int64(42)}, {"nextBatch", A{}}}}},
+		// The cursor is lost.
+		{{"ok", 0}, {"errmsg", "cursor id 42 not found"}, {"code", codeCursorNotFound}},
+		// The find resumed from the last document returns one document and
+		// closes the cursor.
+		{{"ok", 1}, {"cursor", D{{"id", int64(0)}, {"firstBatch", A{D{{"_id", 3}}}}}}},
+		// The next find returns the document that stops the test.
+		{{"ok", 1}, {"cursor", D{{"id", int64(0)}, {"firstBatch", A{D{{"_id", 4}}}}}}},
+	}
+	var commands []string
+	c := newReplyConnection(nil)
+	c.serverInfo.MaxWireVersion = 6
+	c.conn.(*replyConn).handler = func(query M) interface{} {
+		var cmd string
+		switch {
+		case query["find"] != nil:
+			cmd = fmt.Sprintf("find %v tailable=%v awaitData=%v", query["filter"], query["tailable"], query["awaitData"])
+		case query["getMore"] != nil:
+			cmd = fmt.Sprintf("getMore %v maxTimeMS=%v", query["getMore"], query["maxTimeMS"])
+		case query["killCursors"] != nil:
+			return D{{"ok", 1}}
+		}
+		commands = append(commands, cmd)
+		if len(responses) == 0 {
+			return D{{"ok", 0}, {"errmsg", "unexpected command"}}
+		}
+		r := responses[0]
+		responses = responses[1:]
+		return r
+	}
+
+	var ids []int
+	err := (&Query{Conn: c, Namespace: "db.c"}).Tail(context.Background(), tailTestOptions, func(bd BSONData) error {
+		var doc struct {
+			Id int `bson:"_id"`
+		}
+		if err := bd.Decode(&doc); err != nil {
+			return err
+		}
+		ids = append(ids, doc.Id)
+		if doc.Id == 4 {
+			return Done
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{1, 2, 3, 4}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("ids = %v, want %v", ids, expected)
+	}
+	expected := []string{
+		"find <nil> tailable=true awaitData=true",
+		"getMore 42 maxTimeMS=50",
+		"getMore 42 maxTimeMS=50",
+		"find map[_id:map[$gt:2]] tailable=true awaitData=true",
+		"find map[_id:map[$gt:3]] tailable=true awaitData=true",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("commands =\n%q\nwant\n%q", commands, expected)
+	}
+}
+
+func TestTailContext(t *testing.T) {
+	c := newReplyConnection(nil)
+	c.serverInfo.MaxWireVersion = 6
+	c.conn.(*replyConn).handler = func(query M) interface{} {
+		return D{{"ok", 1}, {"cursor", D{{"id", int64(0)}, {"firstBatch", A{}}}}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := (&Query{Conn: c, Namespace: "db.c"}).Tail(ctx, tailTestOptions, func(bd BSONData) error {
+		t.Error("fn called for empty collection")
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Tail() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"iter"
+	"time"
+)
+
+// TypedCollection is a collection of documents that decode to values of type
+// T. The methods of the embedded Collection are available for inserting,
+// updating and removing documents. The iterators returned by the Iter and
+// All methods are range-over-func iterators and require Go 1.23 or later.
+//
+// An example use of TypedCollection is:
+//
+//  users := mongo.NewTypedCollection[User](db.C("users"))
+//  user, err := users.FindOne(mongo.M{"email": email})
+type TypedCollection[T any] struct {
+	Collection
+}
+
+// NewTypedCollection returns a typed collection for c.
+func NewTypedCollection[T any](c Collection) TypedCollection[T] {
+	return TypedCollection[T]{c}
+}
+
+// Find returns a query object for the given filter.
+func (c TypedCollection[T]) Find(filter interface{}) *TypedQuery[T] {
+	return &TypedQuery[T]{c.Collection.Find(filter)}
+}
+
+// FindOne returns the first document matching filter. If no document
+// matches, then FindOne returns mongo.Done.
+func (c TypedCollection[T]) FindOne(filter interface{}) (T, error) {
+	return c.Find(filter).One()
+}
+
+// TypedQuery is a query that returns documents of type T. The query builder
+// methods return the typed query:
+//
+//  result, err := users.Find(mongo.M{"active": true}).Sort(mongo.D{{"name", 1}}).Limit(10).All()
+type TypedQuery[T any] struct {
+	*Query
+}
+
+// MaxAwaitTime is like Query.MaxAwaitTime, but returns the typed query.
+func (q *TypedQuery[T]) MaxAwaitTime(d time.Duration) *TypedQuery[T] {
+	q.Query.MaxAwaitTime(d)
+	return q
+}
+
+// Sort is like Query.Sort, but returns the typed query.
+func (q *TypedQuery[T]) Sort(sort interface{}) *TypedQuery[T] {
+	q.Query.Sort(sort)
+	return q
+}
+
+// Hint is like Query.Hint, but returns the typed query.
+func (q *TypedQuery[T]) Hint(hint interface{}) *TypedQuery[T] {
+	q.Query.Hint(hint)
+	return q
+}
+
+// Limit is like Query.Limit, but returns the typed query.
+func (q *TypedQuery[T]) Limit(limit int) *TypedQuery[T] {
+	q.Query.Limit(limit)
+	return q
+}
+
+// Skip is like Query.Skip, but returns the typed query.
+func (q *TypedQuery[T]) Skip(skip int) *TypedQuery[T] {
+	q.Query.Skip(skip)
+	return q
+}
+
+// BatchSize is like Query.BatchSize, but returns the typed query.
+func (q *TypedQuery[T]) BatchSize(batchSize int) *TypedQuery[T] {
+	q.Query.BatchSize(batchSize)
+	return q
+}
+
+// Fields is like Query.Fields, but returns the typed query.
+func (q *TypedQuery[T]) Fields(fields interface{}) *TypedQuery[T] {
+	q.Query.Fields(fields)
+	return q
+}
+
+// SlaveOk is like Query.SlaveOk, but returns the typed query.
+func (q *TypedQuery[T]) SlaveOk(slaveOk bool) *TypedQuery[T] {
+	q.Query.SlaveOk(slaveOk)
+	return q
+}
+
+// PartialResults is like Query.PartialResults, but returns the typed query.
+func (q *TypedQuery[T]) PartialResults(ok bool) *TypedQuery[T] {
+	q.Query.PartialResults(ok)
+	return q
+}
+
+// Exhaust is like Query.Exhaust, but returns the typed query.
+func (q *TypedQuery[T]) Exhaust(exhaust bool) *TypedQuery[T] {
+	q.Query.Exhaust(exhaust)
+	return q
+}
+
+// Tailable is like Query.Tailable, but returns the typed query.
+func (q *TypedQuery[T]) Tailable(tailable bool) *TypedQuery[T] {
+	q.Query.Tailable(tailable)
+	return q
+}
+
+// Comment is like Query.Comment, but returns the typed query.
+func (q *TypedQuery[T]) Comment(comment interface{}) *TypedQuery[T] {
+	q.Query.Comment(comment)
+	return q
+}
+
+// MaxTime is like Query.MaxTime, but returns the typed query.
+func (q *TypedQuery[T]) MaxTime(d time.Duration) *TypedQuery[T] {
+	q.Query.MaxTime(d)
+	return q
+}
+
+// Collation is like Query.Collation, but returns the typed query.
+func (q *TypedQuery[T]) Collation(collation *Collation) *TypedQuery[T] {
+	q.Query.Collation(collation)
+	return q
+}
+
+// Let is like Query.Let, but returns the typed query.
+func (q *TypedQuery[T]) Let(vars interface{}) *TypedQuery[T] {
+	q.Query.Let(vars)
+	return q
+}
+
+// ReadConcern is like Query.ReadConcern, but returns the typed query.
+func (q *TypedQuery[T]) ReadConcern(level string) *TypedQuery[T] {
+	q.Query.ReadConcern(level)
+	return q
+}
+
+// AllowDiskUse is like Query.AllowDiskUse, but returns the typed query.
+func (q *TypedQuery[T]) AllowDiskUse(allow bool) *TypedQuery[T] {
+	q.Query.AllowDiskUse(allow)
+	return q
+}
+
+// ReturnKey is like Query.ReturnKey, but returns the typed query.
+func (q *TypedQuery[T]) ReturnKey(returnKey bool) *TypedQuery[T] {
+	q.Query.ReturnKey(returnKey)
+	return q
+}
+
+// ShowRecordId is like Query.ShowRecordId, but returns the typed query.
+func (q *TypedQuery[T]) ShowRecordId(show bool) *TypedQuery[T] {
+	q.Query.ShowRecordId(show)
+	return q
+}
+
+// One executes the query and returns the first result.
+func (q *TypedQuery[T]) One() (T, error) {
+	var v T
+	err := q.Query.One(&v)
+	return v, err
+}
+
+// All executes the query and returns the entire result set.
+func (q *TypedQuery[T]) All() ([]T, error) {
+	var result []T
+	err := q.Query.All(&result)
+	return result, err
+}
+
+// Cursor executes the query and returns a cursor over the results.
+func (q *TypedQuery[T]) Cursor() (*TypedCursor[T], error) {
+	cursor, err := q.Query.Cursor()
+	if err != nil {
+		return nil, err
+	}
+	return &TypedCursor[T]{cursor}, nil
+}
+
+// Iter executes the query and returns an iterator over the results. The
+// cursor for the query is closed when the iteration ends. If the query
+// cannot be executed, then the iterator yields the error.
+//
+//  for user, err := range users.Find(nil).Iter() {
+//      if err != nil {
+//          return err
+//      }
+//      ...
+//  }
+func (q *TypedQuery[T]) Iter() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor, err := q.Cursor()
+		if err != nil {
+			var zero T
+			yield(zero, err)
+			return
+		}
+		defer cursor.Close()
+		cursor.All()(yield)
+	}
+}
+
+// TypedCursor is a cursor over documents of type T.
+type TypedCursor[T any] struct {
+	Cursor
+}
+
+// Next fetches the next document from the cursor.
+func (c *TypedCursor[T]) Next() (T, error) {
+	var v T
+	err := c.Cursor.Next(&v)
+	return v, err
+}
+
+// All returns an iterator over the remaining documents in the cursor. The
+// iterator yields an error for documents that cannot be decoded and stops
+// after yielding a permanent cursor error. The iterator does not close the
+// cursor.
+func (c *TypedCursor[T]) All() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for c.HasNext() {
+			v, err := c.Next()
+			if !yield(v, err) {
+				return
+			}
+			if err != nil && c.Err() != nil {
+				return
+			}
+		}
+	}
+}
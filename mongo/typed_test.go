@@ -0,0 +1,107 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"reflect"
+	"testing"
+)
+
+type typedDoc struct {
+	X int    `bson:"x"`
+	S string `bson:"s"`
+}
+
+func newTypedCollection(t *testing.T, docs ...interface{}) TypedCollection[typedDoc] {
+	var p [][]byte
+	for _, doc := range docs {
+		b, err := Encode(nil, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p = append(p, b)
+	}
+	return NewTypedCollection[typedDoc](Collection{Conn: newReplyConnection(p), Namespace: "db.c"})
+}
+
+var typedDocs = []typedDoc{{1, "a"}, {2, "b"}, {3, "c"}}
+
+func TestTypedFind(t *testing.T) {
+	c := newTypedCollection(t, typedDocs[0], typedDocs[1], typedDocs[2])
+
+	doc, err := c.FindOne(nil)
+	if err != nil || doc != typedDocs[0] {
+		t.Errorf("FindOne() = %v, %v, want %v", doc, err, typedDocs[0])
+	}
+
+	all, err := c.Find(nil).All()
+	if err != nil || !reflect.DeepEqual(all, typedDocs) {
+		t.Errorf("All() = %v, %v, want %v", all, err, typedDocs)
+	}
+
+	all, err = c.Find(M{"x": M{"$gt": 0}}).Sort(D{{"x", 1}}).Skip(0).Limit(2).All()
+	if err != nil || !reflect.DeepEqual(all, typedDocs[:2]) {
+		t.Errorf("All() with limit = %v, %v, want %v", all, err, typedDocs[:2])
+	}
+
+	empty := newTypedCollection(t)
+	if _, err := empty.FindOne(nil); err != Done {
+		t.Errorf("FindOne() on empty collection returned %v, want %v", err, Done)
+	}
+}
+
+func TestTypedIter(t *testing.T) {
+	c := newTypedCollection(t, typedDocs[0], M{"x": "bad"}, typedDocs[2])
+
+	var docs []typedDoc
+	var errs int
+	for doc, err := range c.Find(nil).Iter() {
+		if err != nil {
+			errs++
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	expected := []typedDoc{typedDocs[0], typedDocs[2]}
+	if !reflect.DeepEqual(docs, expected) || errs != 1 {
+		t.Errorf("Iter() = %v with %d errors, want %v with 1 error", docs, errs, expected)
+	}
+
+	cursor, err := c.Find(nil).Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	for doc, err := range cursor.All() {
+		if err != nil || doc != typedDocs[0] {
+			t.Errorf("cursor.All() yielded %v, %v, want %v", doc, err, typedDocs[0])
+		}
+		break
+	}
+	if doc, err := cursor.Next(); err == nil {
+		t.Errorf("cursor.Next() = %v, want decode error", doc)
+	}
+	if doc, err := cursor.Next(); err != nil || doc != typedDocs[2] {
+		t.Errorf("cursor.Next() = %v, %v, want %v", doc, err, typedDocs[2])
+	}
+}
+
+func TestTypedPointer(t *testing.T) {
+	c := NewTypedCollection[*typedDoc](newTypedCollection(t, typedDocs[0]).Collection)
+	doc, err := c.FindOne(nil)
+	if err != nil || doc == nil || *doc != typedDocs[0] {
+		t.Errorf("FindOne() = %v, %v, want %v", doc, err, typedDocs[0])
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package update builds update documents.
+//
+// Each function in this package returns an update document with a single
+// operator. Use Combine to merge the documents into one update:
+//
+//  c.Update(mongo.M{"_id": id}, update.Combine(
+//      update.Set("name", name),
+//      update.Inc("visits", 1),
+//      update.CurrentDate("lastVisit")))
+//
+// More information: http://docs.mongodb.org/manual/reference/operator/update/
+package update
+
+import "github.com/garyburd/go-mongo/mongo"
+
+// op returns the update {operator: {name: value}}.
+func op(operator, name string, value interface{}) mongo.D {
+	return mongo.D{{operator, mongo.D{{name, value}}}}
+}
+
+// Set sets the named field to value.
+func Set(name string, value interface{}) mongo.D {
+	return op("$set", name, value)
+}
+
+// Unset removes the named fields.
+func Unset(names ...string) mongo.D {
+	fields := make(mongo.D, len(names))
+	for i, name := range names {
+		fields[i] = mongo.DocItem{Key: name, Value: ""}
+	}
+	return mongo.D{{"$unset", fields}}
+}
+
+// Inc increments the named field by n.
+func Inc(name string, n interface{}) mongo.D {
+	return op("$inc", name, n)
+}
+
+// Rename renames the named field to newName.
+func Rename(name, newName string) mongo.D {
+	return op("$rename", name, newName)
+}
+
+// Min sets the named field to value if value is less than the current value
+// of the field.
+func Min(name string, value interface{}) mongo.D {
+	return op("$min", name, value)
+}
+
+// Max sets the named field to value if value is greater than the current
+// value of the field.
+func Max(name string, value interface{}) mongo.D {
+	return op("$max", name, value)
+}
+
+// CurrentDate sets the named field to the current date.
+func CurrentDate(name string) mongo.D {
+	return op("$currentDate", name, true)
+}
+
+// CurrentTimestamp sets the named field to the current timestamp.
+func CurrentTimestamp(name string) mongo.D {
+	return op("$currentDate", name, mongo.D{{"$type", "timestamp"}})
+}
+
+// Push appends value to the array in the named field.
+func Push(name string, value interface{}) mongo.D {
+	return op("$push", name, value)
+}
+
+// PushEach appends values to the array in the named field.
+func PushEach(name string, values ...interface{}) mongo.D {
+	return op("$push", name, mongo.D{{"$each", mongo.A(values)}})
+}
+
+// AddToSet adds value to the array in the named field if the value is not
+// already in the array.
+func AddToSet(name string, value interface{}) mongo.D {
+	return op("$addToSet", name, value)
+}
+
+// AddToSetEach adds each of the values not already in the array in the named
+// field.
+func AddToSetEach(name string, values ...interface{}) mongo.D {
+	return op("$addToSet", name, mongo.D{{"$each", mongo.A(values)}})
+}
+
+// Pull removes the elements of the array in the named field that equal
+// value. If value is a filter, then the elements matching the filter are
+// removed.
+func Pull(name string, value interface{}) mongo.D {
+	return op("$pull", name, value)
+}
+
+// PullAll removes the elements of the array in the named field that equal
+// any of the values.
+func PullAll(name string, values ...interface{}) mongo.D {
+	return op("$pullAll", name, mongo.A(values))
+}
+
+// Combine merges the updates into a single update document. The fields for
+// an operator are merged in the order that they appear in the arguments.
+// The operator values must be documents of type mongo.D or mongo.M.
+func Combine(updates ...mongo.D) mongo.D {
+	var result mongo.D
+	index := make(map[string]int)
+	for _, u := range updates {
+		for _, item := range u {
+			var fields mongo.D
+			switch v := item.Value.(type) {
+			case mongo.D:
+				fields = v
+			case mongo.M:
+				fields = v.D()
+			default:
+				panic("update: value of operator " + item.Key + " is not a document")
+			}
+			i, found := index[item.Key]
+			if !found {
+				index[item.Key] = len(result)
+				result.Append(item.Key, append(mongo.D(nil), fields...))
+				continue
+			}
+			result[i].Value = append(result[i].Value.(mongo.D), fields...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,65 @@
+// Copyright 2010 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/garyburd/go-mongo/mongo"
+)
+
+type D = mongo.D
+type A = mongo.A
+
+var updateTests = []struct {
+	actual, expected mongo.D
+}{
+	{Set("a", 1), D{{"$set", D{{"a", 1}}}}},
+	{Unset("a", "b"), D{{"$unset", D{{"a", ""}, {"b", ""}}}}},
+	{Inc("a", 2), D{{"$inc", D{{"a", 2}}}}},
+	{Rename("a", "b"), D{{"$rename", D{{"a", "b"}}}}},
+	{Min("a", 1), D{{"$min", D{{"a", 1}}}}},
+	{Max("a", 1), D{{"$max", D{{"a", 1}}}}},
+	{CurrentDate("a"), D{{"$currentDate", D{{"a", true}}}}},
+	{CurrentTimestamp("a"), D{{"$currentDate", D{{"a", D{{"$type", "timestamp"}}}}}}},
+	{Push("a", 1), D{{"$push", D{{"a", 1}}}}},
+	{PushEach("a", 1, 2), D{{"$push", D{{"a", D{{"$each", A{1, 2}}}}}}}},
+	{AddToSet("a", 1), D{{"$addToSet", D{{"a", 1}}}}},
+	{AddToSetEach("a", 1, 2), D{{"$addToSet", D{{"a", D{{"$each", A{1, 2}}}}}}}},
+	{Pull("a", D{{"b", 1}}), D{{"$pull", D{{"a", D{{"b", 1}}}}}}},
+	{PullAll("a", 1, 2), D{{"$pullAll", D{{"a", A{1, 2}}}}}},
+	{
+		Combine(Set("a", 1), Inc("n", 1), Set("b", 2), D{{"$inc", mongo.M{"m": 1}}}),
+		D{{"$set", D{{"a", 1}, {"b", 2}}}, {"$inc", D{{"n", 1}, {"m", 1}}}},
+	},
+	{Combine(), nil},
+}
+
+func TestUpdate(t *testing.T) {
+	for i, tt := range updateTests {
+		if !reflect.DeepEqual(tt.actual, tt.expected) {
+			t.Errorf("%d: got %v, want %v", i, tt.actual, tt.expected)
+		}
+	}
+}
+
+func TestCombineDoesNotModifyArguments(t *testing.T) {
+	set := Set("a", 1)
+	Combine(set, Set("b", 2))
+	if expected := Set("a", 1); !reflect.DeepEqual(set, expected) {
+		t.Errorf("argument modified to %v, want %v", set, expected)
+	}
+}